@@ -2,12 +2,14 @@ package builder
 
 import (
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -20,22 +22,58 @@ var dockerImageForLang = map[string]string{
 	"swift":          "suborbital/builder-swift",
 	"assemblyscript": "suborbital/builder-as",
 	"tinygo":         "suborbital/builder-tinygo",
-	"grain":          "--platform linux/amd64 suborbital/builder-gr",
+	"go":             "suborbital/builder-go",
+	"zig":            "suborbital/builder-zig",
+	"c":              "suborbital/builder-c",
+	"grain":          "suborbital/builder-gr",
 	"typescript":     "suborbital/builder-js",
 	"javascript":     "suborbital/builder-js",
 	"wat":            "suborbital/builder-wat",
 }
 
+// dockerPlatformForLang lists the builder images that are only available for a specific platform.
+var dockerPlatformForLang = map[string]string{
+	"grain": "linux/amd64",
+}
+
+// PlatformForLang returns the platform to run the builder image for lang as on a host with the given
+// architecture (such as runtime.GOARCH), and whether that means it will run under emulation.
+// override (such as $SUBO_BUILDER_PLATFORM) takes precedence, then the only platform the image is
+// available for. An empty platform means the host's own platform is used.
+func PlatformForLang(lang, override, goarch string) (string, bool) {
+	platform := override
+	if platform == "" {
+		platform = dockerPlatformForLang[lang]
+	}
+
+	if platform == "" {
+		return "", false
+	}
+
+	return platform, platform != "linux/"+goarch
+}
+
 // BuildConfig is the configuration for a Builder.
 type BuildConfig struct {
 	JsToolchain   string
 	CommandRunner util.CommandRunner
+	PrereqRetry   RetryPolicy
+	// PrereqConcurrency is the number of modules whose prereqs can run at once, runtime.GOMAXPROCS if less than 1.
+	PrereqConcurrency int
+	// ImagePullTimeout is how long pulling a single builder image may take, DefaultImagePullTimeout if 0.
+	ImagePullTimeout time.Duration
+	// ContinueOnFailure attempts to build every module even if some fail, rather than stopping at the first failure.
+	ContinueOnFailure bool
+	// CacheDir is the directory of the build cache, which holds built .wasm files keyed by their source, dependencies,
+	// and builder image so that unchanged modules are not rebuilt. Caching is disabled if empty and $SUBO_CACHE_DIR is unset.
+	CacheDir string
 }
 
 // DefaultBuildConfig is the default build configuration.
 var DefaultBuildConfig = BuildConfig{
 	JsToolchain:   "npm",
 	CommandRunner: util.Command,
+	PrereqRetry:   DefaultRetryPolicy,
 }
 
 // Builder is capable of building Wasm modules from source.
@@ -45,6 +83,9 @@ type Builder struct {
 
 	results []BuildResult
 
+	// cached holds the Fullpath of each module restored from the build cache.
+	cached map[string]bool
+
 	log util.FriendlyLogger
 }
 
@@ -63,9 +104,14 @@ const (
 
 // ForDirectory creates a Builder bound to a particular directory.
 func ForDirectory(logger util.FriendlyLogger, config *BuildConfig, dir string) (*Builder, error) {
-	ctx, err := project.ForDirectory(dir)
+	return ForDirectoryWithOptions(logger, config, dir, project.Options{})
+}
+
+// ForDirectoryWithOptions creates a Builder bound to a particular directory, whose context is configured by opts.
+func ForDirectoryWithOptions(logger util.FriendlyLogger, config *BuildConfig, dir string, opts project.Options) (*Builder, error) {
+	ctx, err := project.ForDirectoryWithOptions(dir, opts)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to project.ForDirectory")
+		return nil, errors.Wrap(err, "failed to project.ForDirectoryWithOptions")
 	}
 
 	b := &Builder{
@@ -78,31 +124,102 @@ func ForDirectory(logger util.FriendlyLogger, config *BuildConfig, dir string) (
 	return b, nil
 }
 
+// BuildWithToolchain builds every module that passes the context's build filters using the given toolchain.
+// The first failure stops the build unless BuildConfig.ContinueOnFailure is set, in which case every module is
+// attempted and a *BuildFailedError describing all of the failures is returned once they have been.
 func (b *Builder) BuildWithToolchain(tcn Toolchain) error {
 	var err error
 
+	if err := b.checkToolchain(tcn); err != nil {
+		return errors.Wrap(err, "🚫 failed to checkToolchain")
+	}
+
 	b.results = []BuildResult{}
+	b.cached = map[string]bool{}
+
+	// modules that were built before with the same source, dependencies and builder image are restored from the cache.
+	cacheKeys, err := b.restoreCachedModules(tcn)
+	if err != nil {
+		return errors.Wrap(err, "🚫 failed to restoreCachedModules")
+	}
+
+	// every language is built using a builder image, so there's no point starting if they can't run.
+	if tcn == ToolchainDocker {
+		rt, err := NewContainerRuntime(b.Config.CommandRunner)
+		if err != nil {
+			return errors.Wrap(err, "🚫 failed to NewContainerRuntime")
+		}
+
+		if err := rt.CheckAvailable(); err != nil {
+			return errors.Wrap(err, "🚫 failed to CheckAvailable")
+		}
+
+		// pull images explicitly, so that a slow or failed pull isn't mistaken for a failed build.
+		if err := b.PullImages(); err != nil {
+			return errors.Wrap(err, "🚫 failed to PullImages")
+		}
+	}
+
+	// built holds the Fullpath of each module that was built (or restored from the cache).
+	built := map[string]bool{}
+	for path := range b.cached {
+		built[path] = true
+	}
+
+	failures := []error{}
+
+	// failed returns err unless ContinueOnFailure is set, in which case it is recorded so the build can carry on,
+	// and the modules it applies to are not stored in the cache.
+	failed := func(err error, mods ...project.ModuleDir) error {
+		if !b.Config.ContinueOnFailure {
+			return err
+		}
+
+		b.log.LogFail(err.Error())
+		failures = append(failures, err)
+
+		for _, mod := range mods {
+			delete(cacheKeys, mod.Fullpath)
+		}
+
+		return nil
+	}
 
 	// When building in Docker mode, just collect the langs we need to build, and then
 	// launch the associated builder images which will do the building.
 	dockerLangs := map[string]bool{}
+	customImageMods := []project.ModuleDir{}
+
+	// prereqs are independent between modules, so they're all run up front in parallel.
+	prereqResults := map[string]*BuildResult{}
+	if tcn == ToolchainNative {
+		prereqResults, err = b.RunPrereqs()
+		if err != nil {
+			if err := failed(errors.Wrap(err, "🚫 failed to RunPrereqs")); err != nil {
+				return err
+			}
+		}
+	}
 
 	for _, mod := range b.Context.Modules {
-		if !b.Context.ShouldBuildLang(mod.Module.Lang) {
+		if !b.shouldBuild(mod) {
 			continue
 		}
 
 		if tcn == ToolchainNative {
 			b.log.LogStart(fmt.Sprintf("building module: %s (%s)", mod.Name, mod.Module.Lang))
 
-			result := &BuildResult{}
-
-			if err := b.checkAndRunPreReqs(mod, result); err != nil {
-				return errors.Wrap(err, "🚫 failed to checkAndRunPreReqs")
+			result, exists := prereqResults[mod.Fullpath]
+			if !exists {
+				result = &BuildResult{}
 			}
 
 			if flags, err := b.analyzeForCompilerFlags(mod); err != nil {
-				return errors.Wrap(err, "🚫 failed to analyzeForCompilerFlags")
+				if err := failed(errors.Wrapf(err, "🚫 failed to analyzeForCompilerFlags for %s", mod.Name), mod); err != nil {
+					return err
+				}
+
+				continue
 			} else if flags != "" {
 				mod.CompilerFlags = flags
 			}
@@ -114,12 +231,19 @@ func (b *Builder) BuildWithToolchain(tcn Toolchain) error {
 			b.results = append(b.results, *result)
 
 			if err != nil {
-				return errors.Wrapf(err, "🚫 failed to build %s", mod.Name)
+				if err := failed(errors.Wrapf(err, "🚫 failed to build %s", mod.Name), mod); err != nil {
+					return err
+				}
+
+				continue
 			}
 
-			fullWasmFilepath := filepath.Join(mod.Fullpath, fmt.Sprintf("%s.wasm", mod.Name))
-			b.log.LogDone(fmt.Sprintf("%s was built -> %s", mod.Name, fullWasmFilepath))
+			built[mod.Fullpath] = true
 
+			b.log.LogDone(fmt.Sprintf("%s was built -> %s", mod.Name, mod.WasmPath()))
+
+		} else if mod.BuildImage != "" {
+			customImageMods = append(customImageMods, mod)
 		} else {
 			dockerLangs[mod.Module.Lang] = true
 		}
@@ -127,18 +251,111 @@ func (b *Builder) BuildWithToolchain(tcn Toolchain) error {
 
 	if tcn == ToolchainDocker {
 		for lang := range dockerLangs {
+			langMods := []project.ModuleDir{}
+			for _, mod := range b.Context.Modules {
+				if mod.Module.Lang == lang && mod.BuildImage == "" && b.shouldBuild(mod) {
+					langMods = append(langMods, mod)
+				}
+			}
+
+			if b.langHasCachedModules(lang, b.cached) {
+				// the builder image would rebuild the cached modules too, so each of the others gets its own container.
+				for _, mod := range langMods {
+					result, err := b.dockerBuildForModule(mod)
+					if err != nil {
+						if err := failed(errors.Wrapf(err, "failed to dockerBuildForModule %s", mod.Name), mod); err != nil {
+							return err
+						}
+
+						continue
+					}
+
+					b.results = append(b.results, *result)
+					built[mod.Fullpath] = true
+				}
+
+				continue
+			}
+
 			result, err := b.dockerBuildForLang(lang)
 			if err != nil {
-				return errors.Wrap(err, "failed to dockerBuildForDirectory")
+				// a builder image builds every module of its language, so they all fail together.
+				if err := failed(errors.Wrapf(err, "failed to dockerBuildForLang %s", lang), langMods...); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			b.results = append(b.results, *result)
+
+			for _, mod := range langMods {
+				built[mod.Fullpath] = true
+			}
+		}
+
+		// Modules with a custom build image are built last so that their output
+		// takes precedence over any built by the default image for their language.
+		for _, mod := range customImageMods {
+			result, err := b.dockerBuildForModule(mod)
+			if err != nil {
+				if err := failed(errors.Wrapf(err, "failed to dockerBuildForModule %s", mod.Name), mod); err != nil {
+					return err
+				}
+
+				continue
 			}
 
 			b.results = append(b.results, *result)
+			built[mod.Fullpath] = true
 		}
 	}
 
+	if err := b.storeCachedModules(cacheKeys); err != nil {
+		return errors.Wrap(err, "🚫 failed to storeCachedModules")
+	}
+
+	if len(failures) > 0 {
+		builtNames := []string{}
+		for _, mod := range b.Context.Modules {
+			if built[mod.Fullpath] {
+				builtNames = append(builtNames, mod.Name)
+			}
+		}
+
+		return &BuildFailedError{Failures: failures, Built: builtNames}
+	}
+
 	return nil
 }
 
+// checkToolchain returns an error if the context can't be built with the given toolchain. A builder container
+// mounts a single directory, so the Docker toolchain can't build a context with more than one root (see project.ForDirectories).
+func (b *Builder) checkToolchain(tcn Toolchain) error {
+	if tcn == ToolchainDocker && len(b.Context.Roots) > 1 {
+		return fmt.Errorf("the Docker toolchain can't build modules from more than one directory (%s), use the native toolchain instead", strings.Join(b.Context.Roots, ", "))
+	}
+
+	return nil
+}
+
+// BuildFailedError is returned by BuildWithToolchain when BuildConfig.ContinueOnFailure is set and any build failed.
+type BuildFailedError struct {
+	// Failures holds each build failure, in the order they happened.
+	Failures []error
+	// Built holds the names of the modules that were built successfully despite the failures.
+	Built []string
+}
+
+func (e *BuildFailedError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, err := range e.Failures {
+		failures[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d build(s) failed: %s", len(e.Failures), strings.Join(failures, "; "))
+}
+
 // Results returns build results for all of the modules built by this builder
 // returns os.ErrNotExists if none have been built yet.
 func (b *Builder) Results() ([]BuildResult, error) {
@@ -150,20 +367,24 @@ func (b *Builder) Results() ([]BuildResult, error) {
 }
 
 func (b *Builder) dockerBuildForLang(lang string) (*BuildResult, error) {
-	img, err := ImageForLang(lang, b.Context.BuilderTag)
+	if platform, emulated := PlatformForLang(lang, b.Context.BuilderPlatform, runtime.GOARCH); emulated {
+		b.log.LogWarn(fmt.Sprintf("the %s builder image will run as %s under emulation, which may be slow; set $SUBO_BUILDER_PLATFORM to override", lang, platform))
+	}
+
+	img, rt, args, err := b.dockerRunForLang(lang)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to ImageForLang")
+		return nil, errors.Wrap(err, "failed to dockerRunForLang")
 	}
 
 	result := &BuildResult{}
 
-	outputLog, err := b.Config.CommandRunner.Run(fmt.Sprintf("docker run --rm --mount type=bind,source=%s,target=/root/module %s subo build %s --native --langs %s", b.Context.MountPath, img, b.Context.RelDockerPath, lang))
+	outputLog, err := rt.RunBuild(img, b.Context.MountPath, args)
 
 	result.OutputLog = outputLog
 
 	if err != nil {
 		result.Succeeded = false
-		return nil, errors.Wrap(err, "failed to Run docker command")
+		return nil, errors.Wrap(err, "failed to RunBuild")
 	}
 
 	result.Succeeded = true
@@ -171,28 +392,153 @@ func (b *Builder) dockerBuildForLang(lang string) (*BuildResult, error) {
 	return result, nil
 }
 
-// results and resulting file are loaded into the BuildResult pointer.
-func (b *Builder) doNativeBuildForModule(mod project.ModuleDir, result *BuildResult) error {
-	cmds, err := NativeBuildCommands(mod.Module.Lang)
+// dockerCommandForLang returns the builder image and the container command that builds all modules of the given language.
+func (b *Builder) dockerCommandForLang(lang string) (string, string, error) {
+	img, rt, args, err := b.dockerRunForLang(lang)
 	if err != nil {
-		return errors.Wrap(err, "failed to NativeBuildCommands")
+		return "", "", errors.Wrap(err, "failed to dockerRunForLang")
 	}
 
-	for _, cmd := range cmds {
-		cmdTmpl, err := template.New("cmd").Parse(cmd)
-		if err != nil {
-			return errors.Wrap(err, "failed to Parse command template")
+	return img, rt.Command(img, b.Context.MountPath, args), nil
+}
+
+// dockerRunForLang returns the builder image, the container runtime to run it with,
+// and the command to run in the container to build all modules of the given language.
+func (b *Builder) dockerRunForLang(lang string) (string, *CLIRuntime, []string, error) {
+	img, err := b.imageForLang(lang)
+	if err != nil {
+		return "", nil, nil, errors.Wrap(err, "failed to imageForLang")
+	}
+
+	rt, err := NewContainerRuntime(b.Config.CommandRunner)
+	if err != nil {
+		return "", nil, nil, errors.Wrap(err, "failed to NewContainerRuntime")
+	}
+
+	rt.Platform, _ = PlatformForLang(lang, b.Context.BuilderPlatform, runtime.GOARCH)
+	rt.Env = b.buildEnvForLang(lang)
+
+	args := []string{"subo", "build", b.Context.RelDockerPath, "--native", "--langs", lang}
+	if b.Context.BuildFilter != "" {
+		args = append(args, "--filter", b.Context.BuildFilter)
+	}
+
+	if b.Context.SkipPrereqs {
+		args = append(args, "--no-prereqs")
+	}
+
+	if b.Config.ContinueOnFailure {
+		args = append(args, "--continue-on-failure")
+	}
+
+	return img, rt, args, nil
+}
+
+func (b *Builder) dockerBuildForModule(mod project.ModuleDir) (*BuildResult, error) {
+	rt, args, err := b.dockerRunForModule(mod)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dockerRunForModule")
+	}
+
+	img, err := b.imageForModule(mod)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to imageForModule")
+	}
+
+	result := &BuildResult{}
+
+	outputLog, err := rt.RunBuild(img, b.Context.MountPath, args)
+
+	result.OutputLog = outputLog
+
+	if err != nil {
+		result.Succeeded = false
+		return nil, errors.Wrap(err, "failed to RunBuild")
+	}
+
+	result.Succeeded = true
+
+	return result, nil
+}
+
+// dockerRunForModule returns the container runtime and the command to run in the module's
+// builder image (see imageForModule) to build only that module.
+func (b *Builder) dockerRunForModule(mod project.ModuleDir) (*CLIRuntime, []string, error) {
+	relPath, err := filepath.Rel(b.Context.Cwd, mod.Fullpath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get Rel module path")
+	}
+
+	rt, err := NewContainerRuntime(b.Config.CommandRunner)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to NewContainerRuntime")
+	}
+
+	rt.Platform = b.Context.BuilderPlatform
+	if mod.BuildImage == "" {
+		rt.Platform, _ = PlatformForLang(mod.Module.Lang, b.Context.BuilderPlatform, runtime.GOARCH)
+	}
+
+	rt.Env = b.buildEnvForModule(mod)
+
+	args := []string{"subo", "build", filepath.Join(b.Context.RelDockerPath, relPath), "--native"}
+	if b.Context.SkipPrereqs {
+		args = append(args, "--no-prereqs")
+	}
+
+	if b.Config.ContinueOnFailure {
+		args = append(args, "--continue-on-failure")
+	}
+
+	return rt, args, nil
+}
+
+// buildEnvForLang returns the environment for the builder container of the given language: the context's BuildEnv
+// combined with the BuildEnv of each module of that language that will be built.
+func (b *Builder) buildEnvForLang(lang string) map[string]string {
+	env := map[string]string{}
+	for k, v := range b.Context.BuildEnv {
+		env[k] = v
+	}
+
+	for _, mod := range b.Context.Modules {
+		if mod.Module.Lang != lang || mod.BuildImage != "" || !b.shouldBuild(mod) {
+			continue
 		}
 
-		fullCmd := &strings.Builder{}
-		if err := cmdTmpl.Execute(fullCmd, mod); err != nil {
-			return errors.Wrap(err, "failed to Execute command template")
+		for k, v := range mod.BuildEnv {
+			env[k] = v
 		}
+	}
+
+	return env
+}
 
-		cmdString := strings.TrimSpace(fullCmd.String())
+// buildEnvForModule returns the environment for the builder container of a module with a custom build image:
+// the context's BuildEnv combined with the module's own BuildEnv, which takes precedence.
+func (b *Builder) buildEnvForModule(mod project.ModuleDir) map[string]string {
+	env := map[string]string{}
+	for k, v := range b.Context.BuildEnv {
+		env[k] = v
+	}
+
+	for k, v := range mod.BuildEnv {
+		env[k] = v
+	}
 
+	return env
+}
+
+// results and resulting file are loaded into the BuildResult pointer.
+func (b *Builder) doNativeBuildForModule(mod project.ModuleDir, result *BuildResult) error {
+	cmds, err := nativeCommandsForModule(mod)
+	if err != nil {
+		return errors.Wrap(err, "failed to nativeCommandsForModule")
+	}
+
+	for _, cmdString := range cmds {
 		// Even if the command fails, still load the output into the result object.
-		outputLog, err := b.Config.CommandRunner.RunInDir(cmdString, mod.Fullpath)
+		outputLog, err := b.Config.CommandRunner.RunInDir(cmdString, mod.SourceDir)
 
 		result.OutputLog += outputLog + "\n"
 
@@ -204,53 +550,182 @@ func (b *Builder) doNativeBuildForModule(mod project.ModuleDir, result *BuildRes
 		result.Succeeded = true
 	}
 
+	// Modules with a separate source directory produce their .wasm file there,
+	// so move it alongside the module's manifest where the rest of subo expects it.
+	if mod.SourceDir != "" && mod.SourceDir != mod.Fullpath {
+		builtPath := filepath.Join(mod.SourceDir, filepath.Base(mod.WasmPath()))
+
+		if err := os.Rename(builtPath, mod.WasmPath()); err != nil {
+			return errors.Wrap(err, "failed to Rename built module")
+		}
+	}
+
 	return nil
 }
 
+// nativeCommandsForModule returns the native build commands for a module with their templates executed.
+func nativeCommandsForModule(mod project.ModuleDir) ([]string, error) {
+	cmds, err := NativeBuildCommands(mod.Module.Lang)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to NativeBuildCommands")
+	}
+
+	fullCmds := make([]string, len(cmds))
+
+	for i, cmd := range cmds {
+		cmdTmpl, err := template.New("cmd").Funcs(template.FuncMap{
+			"buildFlags": func() string { return buildFlagsArg(mod.BuildFlags) },
+		}).Parse(cmd)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to Parse command template")
+		}
+
+		fullCmd := &strings.Builder{}
+		if err := cmdTmpl.Execute(fullCmd, mod); err != nil {
+			return nil, errors.Wrap(err, "failed to Execute command template")
+		}
+
+		fullCmds[i] = strings.TrimSpace(fullCmd.String())
+	}
+
+	return fullCmds, nil
+}
+
+// SupportedLangs returns the sorted list of languages that have a builder image.
+func SupportedLangs() []string {
+	langs := make([]string, 0, len(dockerImageForLang))
+	for lang := range dockerImageForLang {
+		langs = append(langs, lang)
+	}
+
+	sort.Strings(langs)
+
+	return langs
+}
+
 // ImageForLang returns the Docker image:tag builder for the given language.
 func ImageForLang(lang, tag string) (string, error) {
+	return ImageForLangInRegistry(lang, "", tag)
+}
+
+// ImageForLangInRegistry returns the Docker image:tag builder for the given language,
+// prefixed with registry (such as a private mirror) if it is not empty.
+func ImageForLangInRegistry(lang, registry, tag string) (string, error) {
 	img, ok := dockerImageForLang[lang]
 	if !ok {
-		return "", fmt.Errorf("%s is an unsupported language", lang)
+		return "", fmt.Errorf("%s is an unsupported language, supported languages are: %s", lang, strings.Join(SupportedLangs(), ", "))
+	}
+
+	if registry != "" {
+		img = fmt.Sprintf("%s/%s", strings.TrimSuffix(registry, "/"), img)
 	}
 
 	return fmt.Sprintf("%s:%s", img, tag), nil
 }
 
-func (b *Builder) checkAndRunPreReqs(module project.ModuleDir, result *BuildResult) error {
-	preReqLangs, ok := PreRequisiteCommands[runtime.GOOS]
-	if !ok {
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+// imageForLang returns the context's builder image for lang, pinned to its digest if one is set.
+func (b *Builder) imageForLang(lang string) (string, error) {
+	img, err := ImageForLangInRegistry(lang, b.Context.BuilderRegistry, b.Context.BuilderTagForLang(lang))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to ImageForLangInRegistry")
 	}
 
-	preReqs, ok := preReqLangs[module.Module.Lang]
-	if !ok {
-		return fmt.Errorf("unsupported language: %s", module.Module.Lang)
+	if digest := b.Context.BuilderDigestForLang(lang); digest != "" {
+		img = ImageWithDigest(img, digest)
 	}
 
-	for _, p := range preReqs {
+	return img, nil
+}
 
-		filepathVar := filepath.Join(module.Fullpath, p.File)
+// ImageWithDigest returns image (such as suborbital/builder-rs:v0.6.0) pinned to digest
+// (such as sha256:...), replacing its tag or any existing digest.
+func ImageWithDigest(image, digest string) string {
+	if at := strings.Index(image, "@"); at >= 0 {
+		image = image[:at]
+	}
 
-		if _, err := os.Stat(filepathVar); err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				b.log.LogStart(fmt.Sprintf("missing %s, fixing...", p.File))
+	// a ':' after the last '/' separates the tag, any before it is part of a registry's host:port.
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		image = image[:colon]
+	}
 
-				fullCmd, err := p.GetCommand(*b.Config, module)
-				if err != nil {
-					return errors.Wrap(err, "prereq.GetCommand")
-				}
+	return fmt.Sprintf("%s@%s", image, digest)
+}
 
-				outputLog, err := b.Config.CommandRunner.RunInDir(fullCmd, module.Fullpath)
-				if err != nil {
-					return errors.Wrapf(err, "commandRunner.RunInDir: %s", fullCmd)
-				}
+// moduleImage returns the custom build image of mod, pinned to its BuildImageDigest if it has one.
+func moduleImage(mod project.ModuleDir) string {
+	if mod.BuildImageDigest != "" {
+		return ImageWithDigest(mod.BuildImage, mod.BuildImageDigest)
+	}
+
+	return mod.BuildImage
+}
+
+// RequiredImages returns the sorted builder images that building the context's modules with the Docker toolchain
+// would run, honouring the context's build filters, builder tag, registry and digest overrides, and each module's
+// buildImage, so that they can be pulled ahead of the build (such as to warm the Docker cache in CI).
+// Modules that would be restored from the build cache don't need their builder image.
+func (b *Builder) RequiredImages() ([]string, error) {
+	cached, err := b.cachedModules(ToolchainDocker)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to cachedModules")
+	}
 
-				result.OutputLog += outputLog + "\n"
+	images := map[string]bool{}
 
-				b.log.LogDone("fixed!")
+	for _, mod := range b.Context.Modules {
+		if !b.shouldBuild(mod) || cached[mod.Fullpath] {
+			continue
+		}
+
+		img, err := b.imageForModule(mod)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to imageForModule for %s", mod.Name)
+		}
+
+		images[img] = true
+	}
+
+	required := make([]string, 0, len(images))
+	for img := range images {
+		required = append(required, img)
+	}
+
+	sort.Strings(required)
+
+	return required, nil
+}
+
+func (b *Builder) checkAndRunPreReqs(module project.ModuleDir, result *BuildResult) error {
+	if b.Context.SkipPrereqs {
+		return nil
+	}
+
+	missing, err := MissingPrereqs(module)
+	if err != nil {
+		return errors.Wrap(err, "failed to MissingPrereqs")
+	}
+
+	for _, p := range missing {
+		b.log.LogStart(fmt.Sprintf("(%s) missing %s, fixing...", module.Name, p.File))
+
+		fullCmd, err := p.GetCommand(*b.Config, module)
+		if err != nil {
+			return errors.Wrap(err, "prereq.GetCommand")
+		}
+
+		outputLog, err := RunInDirWithRetry(b.Config.CommandRunner, fullCmd, module.SourceDir, util.RunOptions{Shell: prereqShell(runtime.GOOS)}, b.Config.PrereqRetry)
+		if err != nil {
+			if errors.Is(err, ErrCommandTimeout) {
+				return errors.Wrapf(err, "(%s) prereq for %s timed out: %s", module.Name, p.File, fullCmd)
 			}
+
+			return errors.Wrapf(err, "(%s) prereq for %s failed: %s", module.Name, p.File, fullCmd)
 		}
+
+		result.OutputLog += outputLog + "\n"
+
+		b.log.LogDone(fmt.Sprintf("(%s) fixed!", module.Name))
 	}
 
 	return nil
@@ -260,7 +735,7 @@ func (b *Builder) checkAndRunPreReqs(module project.ModuleDir, result *BuildResu
 // this is initially added to support AS-JSON in AssemblyScript with its need for the --transform flag.
 func (b *Builder) analyzeForCompilerFlags(md project.ModuleDir) (string, error) {
 	if md.Module.Lang == "assemblyscript" {
-		packageJSONBytes, err := ioutil.ReadFile(filepath.Join(md.Fullpath, "package.json"))
+		packageJSONBytes, err := ioutil.ReadFile(filepath.Join(md.SourceDir, "package.json"))
 		if err != nil {
 			return "", errors.Wrap(err, "failed to ReadFile package.json")
 		}
@@ -1,11 +1,11 @@
 # all paths are relative to project root
 ver = $(shell cat ./builder/.image-ver | tr -d '\n')
 
-builder/docker: subo/docker builder/docker/rust builder/docker/swift builder/docker/as builder/docker/tinygo builder/docker/grain builder/docker/javascript builder/docker/wat
+builder/docker: subo/docker builder/docker/rust builder/docker/swift builder/docker/as builder/docker/tinygo builder/docker/go builder/docker/zig builder/docker/c builder/docker/grain builder/docker/javascript builder/docker/wat
 
-builder/docker/publish: subo/docker/publish builder/docker/rust/publish builder/docker/swift/publish builder/docker/as/publish builder/docker/tinygo/publish builder/docker/grain/publish builder/docker/javascript/publish builder/docker/wat/publish
+builder/docker/publish: subo/docker/publish builder/docker/rust/publish builder/docker/swift/publish builder/docker/as/publish builder/docker/tinygo/publish builder/docker/go/publish builder/docker/zig/publish builder/docker/c/publish builder/docker/grain/publish builder/docker/javascript/publish builder/docker/wat/publish
 
-builder/docker/dev/publish: subo/docker/publish builder/docker/rust/dev/publish builder/docker/swift/dev/publish builder/docker/as/dev/publish builder/docker/tinygo/dev/publish builder/docker/grain/dev/publish builder/docker/javascript/dev/publish builder/docker/wat/dev/publish
+builder/docker/dev/publish: subo/docker/publish builder/docker/rust/dev/publish builder/docker/swift/dev/publish builder/docker/as/dev/publish builder/docker/tinygo/dev/publish builder/docker/go/dev/publish builder/docker/zig/dev/publish builder/docker/c/dev/publish builder/docker/grain/dev/publish builder/docker/javascript/dev/publish builder/docker/wat/dev/publish
 
 # AssemblyScript docker targets
 builder/docker/as:
@@ -47,6 +47,36 @@ builder/docker/tinygo/publish:
 builder/docker/tinygo/dev/publish:
 	docker buildx build . -f builder/docker/tinygo/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-tinygo:dev --push
 
+# Go docker targets
+builder/docker/go:
+	DOCKER_BUILDKIT=1 docker build . -f builder/docker/go/Dockerfile -t suborbital/builder-go:$(ver)
+
+builder/docker/go/publish:
+	docker buildx build . -f builder/docker/go/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-go:$(ver) --push
+
+builder/docker/go/dev/publish:
+	docker buildx build . -f builder/docker/go/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-go:dev --push
+
+# Zig docker targets
+builder/docker/zig:
+	DOCKER_BUILDKIT=1 docker build . -f builder/docker/zig/Dockerfile -t suborbital/builder-zig:$(ver)
+
+builder/docker/zig/publish:
+	docker buildx build . -f builder/docker/zig/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-zig:$(ver) --push
+
+builder/docker/zig/dev/publish:
+	docker buildx build . -f builder/docker/zig/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-zig:dev --push
+
+# C docker targets
+builder/docker/c:
+	DOCKER_BUILDKIT=1 docker build . -f builder/docker/c/Dockerfile -t suborbital/builder-c:$(ver)
+
+builder/docker/c/publish:
+	docker buildx build . -f builder/docker/c/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-c:$(ver) --push
+
+builder/docker/c/dev/publish:
+	docker buildx build . -f builder/docker/c/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-c:dev --push
+
 # Grain docker targets
 builder/docker/grain:
 	docker buildx build . -f builder/docker/grain/Dockerfile --platform linux/amd64 -t suborbital/builder-gr:$(ver) --load
@@ -77,4 +107,4 @@ builder/docker/wat/publish:
 builder/docker/wat/dev/publish:
 	docker buildx build . -f builder/docker/wat/Dockerfile --platform linux/amd64,linux/arm64 -t suborbital/builder-wat:dev --push
 
-.PHONY: builder/docker builder/docker/publish builder/docker/as builder/docker/as/publish builder/docker/rust builder/docker/rust/publish builder/docker/swift builder/docker/swift/publish builder/docker/tinygo builder/docker/tinygo/publish builder/docker/grain builder/docker/grain/publish builder/docker/javascript builder/docker/javascript/publish builder/docker/wat builder/docker/wat/publish
+.PHONY: builder/docker builder/docker/publish builder/docker/as builder/docker/as/publish builder/docker/rust builder/docker/rust/publish builder/docker/swift builder/docker/swift/publish builder/docker/tinygo builder/docker/tinygo/publish builder/docker/go builder/docker/go/publish builder/docker/zig builder/docker/zig/publish builder/docker/c builder/docker/c/publish builder/docker/grain builder/docker/grain/publish builder/docker/javascript builder/docker/javascript/publish builder/docker/wat builder/docker/wat/publish
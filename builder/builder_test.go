@@ -0,0 +1,361 @@
+package builder
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/util"
+	"github.com/suborbital/systemspec/tenant"
+)
+
+func TestImageForLang(t *testing.T) {
+	tests := []struct {
+		name    string
+		lang    string
+		tag     string
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "returns a versioned image for rust",
+			lang:    "rust",
+			tag:     "v0.6.0",
+			want:    "suborbital/builder-rs:v0.6.0",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "returns a versioned image for go",
+			lang:    "go",
+			tag:     "v0.6.0",
+			want:    "suborbital/builder-go:v0.6.0",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "returns a versioned image for zig",
+			lang:    "zig",
+			tag:     "v0.6.0",
+			want:    "suborbital/builder-zig:v0.6.0",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "returns a versioned image for c",
+			lang:    "c",
+			tag:     "v0.6.0",
+			want:    "suborbital/builder-c:v0.6.0",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "errors for an unsupported language",
+			lang:    "cobol",
+			tag:     "v0.6.0",
+			want:    "",
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImageForLang(tt.lang, tt.tag)
+
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestImageForLangInRegistry(t *testing.T) {
+	tests := []struct {
+		name     string
+		lang     string
+		registry string
+		want     string
+	}{
+		{
+			name:     "uses the default registry when none is set",
+			lang:     "rust",
+			registry: "",
+			want:     "suborbital/builder-rs:v0.6.0",
+		},
+		{
+			name:     "prefixes the registry",
+			lang:     "rust",
+			registry: "registry.internal",
+			want:     "registry.internal/suborbital/builder-rs:v0.6.0",
+		},
+		{
+			name:     "tolerates a trailing slash on the registry",
+			lang:     "tinygo",
+			registry: "registry.internal/",
+			want:     "registry.internal/suborbital/builder-tinygo:v0.6.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImageForLangInRegistry(tt.lang, tt.registry, "v0.6.0")
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestForDirectory_Zig(t *testing.T) {
+	root := t.TempDir()
+	modDir := filepath.Join(root, "hello-zig")
+	require.NoError(t, os.MkdirAll(modDir, util.PermDirectory))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(modDir, ".module.yaml"), []byte("name: hello-zig\nlang: zig\n"), util.PermFile))
+
+	bdr, err := ForDirectory(&util.PrintLogger{}, &DefaultBuildConfig, root)
+	require.NoError(t, err)
+	require.Len(t, bdr.Context.Modules, 1)
+
+	mod := bdr.Context.Modules[0]
+	assert.Equal(t, "hello-zig", mod.Name)
+	assert.Equal(t, "hello_zig", mod.UnderscoreName)
+	assert.Equal(t, modDir, mod.Fullpath)
+
+	img, _, err := bdr.dockerCommandForLang(mod.Module.Lang)
+	require.NoError(t, err)
+	assert.Equal(t, "suborbital/builder-zig:"+bdr.Context.BuilderTag, img)
+}
+
+func TestNativeCommandsForModule_BuildFlags(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("native builds are not supported on windows")
+	}
+
+	tests := []struct {
+		name      string
+		flags     []string
+		wantBuild string
+	}{
+		{"no flags", nil, "cargo vendor && cargo build --target wasm32-wasi --lib --release"},
+		{"features", []string{"--features", "json,yaml"}, "cargo vendor && cargo build --target wasm32-wasi --lib --release '--features' 'json,yaml'"},
+		{"injection", []string{"--features=a'; rm -rf / #"}, `cargo vendor && cargo build --target wasm32-wasi --lib --release '--features=a'\''; rm -rf / #'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mod := project.ModuleDir{Name: "mod", UnderscoreName: "mod", Module: &tenant.Module{Lang: "rust"}, BuildFlags: tt.flags}
+
+			cmds, err := nativeCommandsForModule(mod)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantBuild, cmds[0])
+			assert.Equal(t, "cp target/wasm32-wasi/release/mod.wasm ./mod.wasm", cmds[1])
+		})
+	}
+}
+
+func TestPlatformForLang(t *testing.T) {
+	tests := []struct {
+		name         string
+		lang         string
+		override     string
+		goarch       string
+		wantPlatform string
+		wantEmulated bool
+	}{
+		{"multi-arch image on amd64", "rust", "", "amd64", "", false},
+		{"multi-arch image on arm64", "rust", "", "arm64", "", false},
+		{"amd64-only image on amd64", "grain", "", "amd64", "linux/amd64", false},
+		{"amd64-only image on arm64", "grain", "", "arm64", "linux/amd64", true},
+		{"override", "rust", "linux/arm64", "arm64", "linux/arm64", false},
+		{"override on another arch", "grain", "linux/arm64", "amd64", "linux/arm64", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform, emulated := PlatformForLang(tt.lang, tt.override, tt.goarch)
+
+			assert.Equal(t, tt.wantPlatform, platform)
+			assert.Equal(t, tt.wantEmulated, emulated)
+		})
+	}
+}
+
+func TestSupportedLangs(t *testing.T) {
+	langs := SupportedLangs()
+
+	assert.Len(t, langs, len(dockerImageForLang))
+	assert.True(t, sort.StringsAreSorted(langs))
+	assert.Contains(t, langs, "rust")
+
+	_, err := ImageForLang("cobol", "v0.6.0")
+	assert.ErrorContains(t, err, strings.Join(langs, ", "))
+}
+
+func TestForDirectory_BuilderTagForLang(t *testing.T) {
+	t.Setenv("SUBO_BUILDER_TAG", "v0.5.0")
+	t.Setenv("SUBO_BUILDER_TAG_rust", "v0.4.0")
+	t.Setenv("SUBO_BUILDER_TAG_SWIFT", "v0.3.0")
+
+	bdr, err := ForDirectory(&util.PrintLogger{}, &DefaultBuildConfig, t.TempDir())
+	require.NoError(t, err)
+
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"rust", "suborbital/builder-rs:v0.4.0"},
+		{"swift", "suborbital/builder-swift:v0.3.0"},
+		{"tinygo", "suborbital/builder-tinygo:v0.5.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			img, _, err := bdr.dockerCommandForLang(tt.lang)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, img)
+		})
+	}
+}
+
+func TestImageWithDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"suborbital/builder-rs:v0.6.0", "suborbital/builder-rs@" + digest},
+		{"suborbital/builder-rs", "suborbital/builder-rs@" + digest},
+		{"registry.example.com:5000/suborbital/builder-rs:v0.6.0", "registry.example.com:5000/suborbital/builder-rs@" + digest},
+		{"registry.example.com:5000/suborbital/builder-rs", "registry.example.com:5000/suborbital/builder-rs@" + digest},
+		{"suborbital/builder-rs@sha256:" + strings.Repeat("b", 64), "suborbital/builder-rs@" + digest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			assert.Equal(t, tt.want, ImageWithDigest(tt.image, digest))
+		})
+	}
+}
+
+func TestForDirectory_BuilderDigestForLang(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0123456789abcdef", 4)
+	t.Setenv("SUBO_BUILDER_DIGEST_rust", digest)
+
+	bdr, err := ForDirectory(&util.PrintLogger{}, &DefaultBuildConfig, t.TempDir())
+	require.NoError(t, err)
+
+	img, _, err := bdr.dockerCommandForLang("rust")
+	require.NoError(t, err)
+	assert.Equal(t, "suborbital/builder-rs@"+digest, img)
+
+	t.Setenv("SUBO_BUILDER_DIGEST_rust", "latest")
+
+	_, err = ForDirectory(&util.PrintLogger{}, &DefaultBuildConfig, t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestBuilder_RequiredImages(t *testing.T) {
+	mod := func(name, lang, image string) project.ModuleDir {
+		return project.ModuleDir{Name: name, Fullpath: "/" + name, BuildImage: image, Module: &tenant.Module{Name: name, Lang: lang}}
+	}
+
+	b := &Builder{
+		Context: &project.Context{
+			Modules: []project.ModuleDir{
+				mod("a", "rust", ""),
+				mod("b", "tinygo", ""),
+				mod("c", "rust", ""),
+				mod("d", "rust", "example.com/custom:v1"),
+				mod("e", "swift", ""),
+			},
+			Langs:              []string{"rust", "tinygo"},
+			BuilderTag:         "v0.6.0",
+			BuilderTagsForLang: map[string]string{"tinygo": "v0.5.0"},
+			BuilderRegistry:    "mirror.example.com",
+		},
+		Config: &DefaultBuildConfig,
+		log:    &util.PrintLogger{},
+	}
+
+	images, err := b.RequiredImages()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"example.com/custom:v1",
+		"mirror.example.com/suborbital/builder-rs:v0.6.0",
+		"mirror.example.com/suborbital/builder-tinygo:v0.5.0",
+	}, images)
+}
+
+func TestBuilder_MultipleRoots(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	for _, mod := range []struct{ root, name string }{{first, "auth"}, {second, "greet"}} {
+		dir := filepath.Join(mod.root, mod.name)
+		require.NoError(t, os.MkdirAll(dir, util.PermDirectory))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".module.yaml"), []byte("name: "+mod.name+"\nlang: rust\n"), util.PermFile))
+	}
+
+	ctx, err := project.ForDirectories([]string{first, second})
+	require.NoError(t, err)
+
+	ctx.SkipPrereqs = true
+	b := &Builder{Context: ctx, Config: &BuildConfig{CommandRunner: &recordingRunner{}}, log: &util.PrintLogger{}}
+
+	steps, err := b.Plan(ToolchainNative)
+	require.NoError(t, err)
+
+	dirs := map[string]string{}
+	for _, step := range steps {
+		dirs[step.Module] = step.Dir
+	}
+
+	assert.Equal(t, filepath.Join(second, "greet"), dirs["greet"], "a module in the second directory is built in place")
+
+	_, err = b.Plan(ToolchainDocker)
+	assert.ErrorContains(t, err, "more than one directory")
+	assert.ErrorContains(t, b.BuildWithToolchain(ToolchainDocker), "more than one directory")
+}
+
+func TestBuilder_ContinueOnFailure(t *testing.T) {
+	mod := func(name string) project.ModuleDir {
+		dir := t.TempDir()
+
+		return project.ModuleDir{Name: name, Fullpath: dir, SourceDir: dir, Module: &tenant.Module{Name: name, Lang: "wat"}}
+	}
+
+	// the recording runner fails any command containing "fail", which the build command for failing.wasm does.
+	modules := []project.ModuleDir{mod("first"), mod("failing"), mod("last")}
+
+	tests := []struct {
+		name              string
+		continueOnFailure bool
+		wantCmds          int
+	}{
+		{"stops at first failure", false, 2},
+		{"continues past failures", true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &recordingRunner{}
+			b := &Builder{
+				Context: &project.Context{Modules: modules},
+				Config:  &BuildConfig{CommandRunner: runner, ContinueOnFailure: tt.continueOnFailure},
+				log:     &util.PrintLogger{},
+			}
+
+			err := b.BuildWithToolchain(ToolchainNative)
+			assert.ErrorContains(t, err, "failed to build failing")
+			assert.Len(t, runner.cmds, tt.wantCmds)
+
+			var failedErr *BuildFailedError
+			if assert.Equal(t, tt.continueOnFailure, errors.As(err, &failedErr)) && tt.continueOnFailure {
+				assert.Len(t, failedErr.Failures, 1)
+				assert.Equal(t, []string{"first", "last"}, failedErr.Built)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/util"
+)
+
+// cacheDirEnvKey is the environment variable that sets the build cache directory, overriding BuildConfig.CacheDir.
+const cacheDirEnvKey = "SUBO_CACHE_DIR"
+
+// cacheDir returns the directory of the build cache, or an empty string if caching is disabled.
+// $SUBO_CACHE_DIR takes precedence over the config's CacheDir.
+func (b *Builder) cacheDir() string {
+	if envDir, exists := os.LookupEnv(cacheDirEnvKey); exists && envDir != "" {
+		return envDir
+	}
+
+	return b.Config.CacheDir
+}
+
+// shouldBuild returns true if the module passes the context's build filters and was not restored from the build cache.
+func (b *Builder) shouldBuild(mod project.ModuleDir) bool {
+	return b.Context.ShouldBuild(mod) && !b.cached[mod.Fullpath]
+}
+
+// cachedModules returns the Fullpath of each module that passes the context's build filters and has an entry in the
+// build cache for the given toolchain, i.e. the modules that a build would restore rather than rebuild.
+func (b *Builder) cachedModules(tcn Toolchain) (map[string]bool, error) {
+	cached := map[string]bool{}
+
+	dir := b.cacheDir()
+	if dir == "" {
+		return cached, nil
+	}
+
+	for _, mod := range b.Context.Modules {
+		if !b.Context.ShouldBuild(mod) {
+			continue
+		}
+
+		key, err := b.cacheKey(mod, tcn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to cacheKey for %s", mod.Name)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, key+".wasm")); err == nil {
+			cached[mod.Fullpath] = true
+		}
+	}
+
+	return cached, nil
+}
+
+// langHasCachedModules returns true if any module of lang that is built with the language's builder image is in cached.
+// The builder image builds every module of its language that passes the build filters, so the uncached modules of
+// such a language are each built in their own container instead.
+func (b *Builder) langHasCachedModules(lang string, cached map[string]bool) bool {
+	for _, mod := range b.Context.Modules {
+		if mod.Module.Lang == lang && mod.BuildImage == "" && cached[mod.Fullpath] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// imageForModule returns the builder image that builds the module with the Docker toolchain.
+func (b *Builder) imageForModule(mod project.ModuleDir) (string, error) {
+	if mod.BuildImage != "" {
+		return moduleImage(mod), nil
+	}
+
+	return b.imageForLang(mod.Module.Lang)
+}
+
+// cacheKey returns the module's key in the build cache, the SHA256 of its SourceHash, its DependencyLockHash,
+// and the toolchain and builder image that build it, so that a change to any of them is a cache miss.
+func (b *Builder) cacheKey(mod project.ModuleDir, tcn Toolchain) (string, error) {
+	sourceHash, err := mod.SourceHash()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to SourceHash")
+	}
+
+	lockHash, err := mod.DependencyLockHash()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to DependencyLockHash")
+	}
+
+	img, err := b.imageForModule(mod)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to imageForModule")
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s\n", sourceHash, lockHash, tcn, img)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// restoreCachedModules copies the .wasm file of each module that will be built from the build cache if there is an
+// entry for its cache key, marking it as cached so that it is not rebuilt. The keys of the modules that missed the
+// cache are returned, keyed by Fullpath, so that their entries can be stored once they are built. With the Docker
+// toolchain, the modules of a language that missed the cache are each built in their own container when any
+// other module of that language was restored (see langHasCachedModules).
+func (b *Builder) restoreCachedModules(tcn Toolchain) (map[string]string, error) {
+	dir := b.cacheDir()
+	if dir == "" {
+		return nil, nil
+	}
+
+	misses := map[string]string{}
+
+	for _, mod := range b.Context.Modules {
+		if !b.Context.ShouldBuild(mod) {
+			continue
+		}
+
+		key, err := b.cacheKey(mod, tcn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to cacheKey for %s", mod.Name)
+		}
+
+		err = copyFile(filepath.Join(dir, key+".wasm"), mod.WasmPath())
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				misses[mod.Fullpath] = key
+				continue
+			}
+
+			return nil, errors.Wrapf(err, "failed to restore %s from the build cache", mod.Name)
+		}
+
+		b.cached[mod.Fullpath] = true
+		b.results = append(b.results, BuildResult{Succeeded: true, OutputLog: fmt.Sprintf("restored %s from the build cache\n", mod.Name)})
+
+		b.log.LogDone(fmt.Sprintf("%s was restored from the build cache -> %s", mod.Name, mod.WasmPath()))
+	}
+
+	return misses, nil
+}
+
+// storeCachedModules copies the built .wasm file of each module in keys into the build cache under its key.
+func (b *Builder) storeCachedModules(keys map[string]string) error {
+	dir := b.cacheDir()
+	if dir == "" || len(keys) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, util.PermDirectory); err != nil {
+		return errors.Wrap(err, "failed to MkdirAll cache directory")
+	}
+
+	for _, mod := range b.Context.Modules {
+		key, exists := keys[mod.Fullpath]
+		if !exists {
+			continue
+		}
+
+		if err := copyFile(mod.WasmPath(), filepath.Join(dir, key+".wasm")); err != nil {
+			return errors.Wrapf(err, "failed to store %s in the build cache", mod.Name)
+		}
+	}
+
+	return nil
+}
+
+// copyFile copies the file at src to dst. The copy is written to a temporary file that is renamed to dst,
+// so that a cache shared between concurrent builds never contains a partially written file.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to TempFile")
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to Copy")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to Close")
+	}
+
+	if err := os.Chmod(tmp.Name(), util.PermFile); err != nil {
+		return errors.Wrap(err, "failed to Chmod")
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return errors.Wrap(err, "failed to Rename")
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/util"
+	"github.com/suborbital/systemspec/tenant"
+)
+
+func TestBuilder_BuildCache(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lib.wat"), []byte("(module)"), util.PermFile))
+
+	mod := project.ModuleDir{
+		Name:      "hello",
+		Fullpath:  dir,
+		SourceDir: dir,
+		Module:    &tenant.Module{Name: "hello", Lang: "wat"},
+	}
+
+	runner := &recordingRunner{}
+	b := &Builder{
+		Context: &project.Context{Modules: []project.ModuleDir{mod}, BuilderTag: "v0.6.0"},
+		Config:  &BuildConfig{CommandRunner: runner, CacheDir: filepath.Join(t.TempDir(), "cache")},
+		log:     &util.PrintLogger{},
+	}
+
+	// build runs the native build, whose output the recording runner doesn't produce, so it is written here instead.
+	build := func() {
+		require.NoError(t, ioutil.WriteFile(mod.WasmPath(), []byte("built"), util.PermFile))
+		require.NoError(t, b.BuildWithToolchain(ToolchainNative))
+	}
+
+	build()
+	assert.Len(t, runner.cmds, 1, "the first build misses the cache")
+
+	require.NoError(t, os.Remove(mod.WasmPath()))
+	require.NoError(t, b.BuildWithToolchain(ToolchainNative))
+	assert.Len(t, runner.cmds, 1, "an unchanged module is restored from the cache")
+	assert.FileExists(t, mod.WasmPath())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lib.wat"), []byte("(module (memory 1))"), util.PermFile))
+	build()
+	assert.Len(t, runner.cmds, 2, "a changed module misses the cache")
+
+	b.Context.BuilderTag = "v0.7.0"
+	build()
+	assert.Len(t, runner.cmds, 3, "a different builder image misses the cache")
+}
+
+func TestBuilder_PlanSkipsCachedModules(t *testing.T) {
+	root := t.TempDir()
+	mods := []project.ModuleDir{}
+	for _, name := range []string{"cached", "changed"} {
+		dir := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(dir, util.PermDirectory))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lib.wat"), []byte("(module) ;; "+name), util.PermFile))
+
+		mods = append(mods, project.ModuleDir{Name: name, Fullpath: dir, SourceDir: dir, Module: &tenant.Module{Name: name, Lang: "wat"}})
+	}
+
+	cacheDir := t.TempDir()
+	b := &Builder{
+		Context: &project.Context{Modules: mods, Cwd: root, MountPath: root, RelDockerPath: ".", BuilderTag: "v0.6.0"},
+		Config:  &BuildConfig{CommandRunner: &recordingRunner{}, CacheDir: cacheDir},
+		log:     &util.PrintLogger{},
+	}
+
+	steps, err := b.Plan(ToolchainDocker)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	assert.Empty(t, steps[0].Module, "with nothing cached, one container builds the whole language")
+
+	key, err := b.cacheKey(mods[0], ToolchainDocker)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, key+".wasm"), []byte("built"), util.PermFile))
+
+	steps, err = b.Plan(ToolchainDocker)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	assert.Equal(t, "changed", steps[0].Module, "only the uncached module is built")
+	assert.Contains(t, steps[0].Args, "changed")
+	assert.NotContains(t, steps[0].Command, "--langs")
+
+	images, err := b.RequiredImages()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"suborbital/builder-wat:v0.6.0"}, images)
+
+	key, err = b.cacheKey(mods[1], ToolchainDocker)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, key+".wasm"), []byte("built"), util.PermFile))
+
+	images, err = b.RequiredImages()
+	require.NoError(t, err)
+	assert.Empty(t, images, "no builder image is needed when every module is cached")
+}
@@ -0,0 +1,197 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+// containerRuntimeEnvKey is the environment variable that selects the CLI used to run builder images.
+const containerRuntimeEnvKey = "SUBO_CONTAINER_RUNTIME"
+
+// defaultContainerCLI is used to run builder images unless another runtime is selected.
+const defaultContainerCLI = "docker"
+
+// supportedContainerCLIs are the docker-compatible CLIs that can run builder images.
+var supportedContainerCLIs = map[string]bool{
+	"docker":  true,
+	"podman":  true,
+	"nerdctl": true,
+}
+
+// ContainerRuntime runs builder images.
+type ContainerRuntime interface {
+	// RunBuild runs cmd in a container created from image, with dir mounted at /root/module,
+	// returning the container's output.
+	RunBuild(image, dir string, cmd []string) (string, error)
+}
+
+// CLIRuntime is a ContainerRuntime that uses a docker-compatible CLI such as docker, podman, or nerdctl.
+type CLIRuntime struct {
+	CLI      string
+	Platform string
+	// Env is set in the container. It may contain secrets, so only its keys are part of the container command,
+	// and its values are passed in the environment the container CLI is run with (see Environ).
+	Env    map[string]string
+	Runner util.CommandRunner
+}
+
+// ContainerCLI returns the container CLI selected by $SUBO_CONTAINER_RUNTIME, defaulting to docker.
+func ContainerCLI() (string, error) {
+	cli, exists := os.LookupEnv(containerRuntimeEnvKey)
+	if !exists || cli == "" {
+		return defaultContainerCLI, nil
+	}
+
+	if !supportedContainerCLIs[cli] {
+		return "", fmt.Errorf("%s is not a supported container runtime, use one of docker, podman, or nerdctl", cli)
+	}
+
+	return cli, nil
+}
+
+// NewContainerRuntime returns a CLIRuntime for the container CLI selected by $SUBO_CONTAINER_RUNTIME.
+func NewContainerRuntime(runner util.CommandRunner) (*CLIRuntime, error) {
+	cli, err := ContainerCLI()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ContainerCLI")
+	}
+
+	return &CLIRuntime{CLI: cli, Runner: runner}, nil
+}
+
+// RunBuild runs cmd in a container created from image, with dir mounted at /root/module,
+// returning the container's output.
+func (c *CLIRuntime) RunBuild(image, dir string, cmd []string) (string, error) {
+	outputLog, err := runWithOptions(c.Runner, c.Command(image, dir, cmd), "", util.RunOptions{Env: c.Environ()})
+	if err != nil {
+		return outputLog, errors.Wrapf(err, "failed to Run %s command", c.CLI)
+	}
+
+	return outputLog, nil
+}
+
+// Command returns the command line that RunBuild would run. Only the keys of Env are included, each
+// container variable takes its value from the same variable in the environment the command runs with.
+func (c *CLIRuntime) Command(image, dir string, cmd []string) string {
+	imgArg := shellQuote(image)
+	if c.Platform != "" {
+		imgArg = fmt.Sprintf("--platform %s %s", shellQuote(c.Platform), imgArg)
+	}
+
+	envArgs := ""
+	for _, key := range sortedEnvKeys(c.Env) {
+		envArgs += fmt.Sprintf("-e %s ", shellQuote(key))
+	}
+
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = shellQuote(arg)
+	}
+
+	mountArg := shellQuote(fmt.Sprintf("type=bind,source=%s,target=/root/module", dir))
+
+	return fmt.Sprintf("%s run --rm --mount %s %s%s %s", c.CLI, mountArg, envArgs, imgArg, strings.Join(quoted, " "))
+}
+
+// Args returns the same command as Command as a list of arguments, which can be passed to exec.Command without a shell.
+// Unlike Command, nothing is quoted. Like Command, the values of Env must be set in the environment it runs with.
+func (c *CLIRuntime) Args(image, dir string, cmd []string) []string {
+	args := []string{c.CLI, "run", "--rm", "--mount", fmt.Sprintf("type=bind,source=%s,target=/root/module", dir)}
+
+	for _, key := range sortedEnvKeys(c.Env) {
+		args = append(args, "-e", key)
+	}
+
+	if c.Platform != "" {
+		args = append(args, "--platform", c.Platform)
+	}
+
+	args = append(args, image)
+
+	return append(args, cmd...)
+}
+
+// shellQuote single-quotes arg if it contains anything other than characters that the shell never interprets.
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, shellSafeChars) == "" {
+		return arg
+	}
+
+	return singleQuote(arg)
+}
+
+// shellSafeChars are the characters that can appear in a shell word without being quoted.
+const shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.,/:=@+%"
+
+// singleQuote wraps arg in single quotes, so that the shell passes it on verbatim. Nothing is
+// interpreted within single quotes, so any single quote in arg ends the quoting, is escaped, and restarts it.
+func singleQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// Environ returns Env formatted as KEY=value, sorted by key, to be added to the environment that Command or Args is run with.
+func (c *CLIRuntime) Environ() []string {
+	environ := make([]string, 0, len(c.Env))
+	for _, key := range sortedEnvKeys(c.Env) {
+		environ = append(environ, fmt.Sprintf("%s=%s", key, c.Env[key]))
+	}
+
+	return environ
+}
+
+// runWithOptions runs cmd in dir using runner with opts, which requires runner to be a util.OptionsCommandRunner
+// if opts sets an environment or stdin. Other runners ignore opts.Silent.
+func runWithOptions(runner util.CommandRunner, cmd, dir string, opts util.RunOptions) (string, error) {
+	return runWithOptionsContext(context.Background(), runner, cmd, dir, opts)
+}
+
+// runWithOptionsContext is runWithOptions, killing the command when ctx is done if runner is a
+// util.ContextCommandRunner. Runners that aren't util.OptionsCommandRunners are given the command line that runs cmd
+// with opts.Shell, which they run with sh.
+func runWithOptionsContext(ctx context.Context, runner util.CommandRunner, cmd, dir string, opts util.RunOptions) (string, error) {
+	if optsRunner, ok := runner.(util.OptionsCommandRunner); ok {
+		return optsRunner.RunInDirWithOptions(ctx, cmd, dir, opts)
+	}
+
+	if len(opts.Env) > 0 || opts.Stdin != nil {
+		return "", fmt.Errorf("%T is not a util.OptionsCommandRunner, so it can't set the environment or stdin of a command", runner)
+	}
+
+	if len(opts.Shell) > 0 {
+		cmd = fmt.Sprintf("%s %s", strings.Join(opts.Shell, " "), shellQuote(cmd))
+	}
+
+	if ctxRunner, ok := runner.(util.ContextCommandRunner); ok {
+		return ctxRunner.RunInDirContext(ctx, cmd, dir)
+	}
+
+	return runner.RunInDir(cmd, dir)
+}
+
+func sortedEnvKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// CheckAvailable returns an error explaining how to fix things if the runtime's container CLI
+// is not installed or is not able to run containers, checking it with the runtime's Runner.
+func (c *CLIRuntime) CheckAvailable() error {
+	if _, err := runWithOptions(c.Runner, fmt.Sprintf("%s info", c.CLI), "", util.RunOptions{Silent: true}); err != nil {
+		return fmt.Errorf("%s is not installed or is not able to run containers, install it (or Docker from https://docs.docker.com/get-docker/) and make sure it is running, or build with --native", c.CLI)
+	}
+
+	return nil
+}
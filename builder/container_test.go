@@ -0,0 +1,137 @@
+package builder
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+func TestNewContainerRuntime(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "defaults to docker",
+			env:     "",
+			want:    "docker run --rm --mount type=bind,source=/src,target=/root/module suborbital/builder-rs:v0.6.0 subo build . --native",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "uses podman when selected",
+			env:     "podman",
+			want:    "podman run --rm --mount type=bind,source=/src,target=/root/module suborbital/builder-rs:v0.6.0 subo build . --native",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "errors for an unsupported runtime",
+			env:     "lxc",
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(containerRuntimeEnvKey, tt.env)
+
+			rt, err := NewContainerRuntime(DefaultBuildConfig.CommandRunner)
+
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, tt.want, rt.Command("suborbital/builder-rs:v0.6.0", "/src", []string{"subo", "build", ".", "--native"}))
+		})
+	}
+}
+
+func TestCLIRuntime_Env(t *testing.T) {
+	rt := &CLIRuntime{CLI: "docker", Env: map[string]string{"NPM_TOKEN": "s3cret", "CARGO_HOME": "/cargo"}}
+
+	cmd := rt.Command("suborbital/builder-js:v0.6.0", "/src", []string{"subo", "build", ".", "--native"})
+	assert.Equal(t, `docker run --rm --mount type=bind,source=/src,target=/root/module -e CARGO_HOME -e NPM_TOKEN suborbital/builder-js:v0.6.0 subo build . --native`, cmd)
+	assert.Equal(t, []string{"CARGO_HOME=/cargo", "NPM_TOKEN=s3cret"}, rt.Environ())
+}
+
+func TestCLIRuntime_RunBuild(t *testing.T) {
+	runner := &recordingRunner{}
+	rt := &CLIRuntime{CLI: "docker", Env: map[string]string{"NPM_TOKEN": "$(s3cret)"}, Runner: runner}
+
+	out, err := rt.RunBuild("suborbital/builder-js:v0.6.0", "/src", []string{"subo", "build", "."})
+	require.NoError(t, err)
+	require.Len(t, runner.cmds, 1)
+	assert.Equal(t, "ran "+runner.cmds[0], out)
+	assert.NotContains(t, runner.cmds[0], "s3cret")
+	assert.Equal(t, []string{"NPM_TOKEN=$(s3cret)"}, runner.envs[0])
+
+	rt.Runner = &flakyRunner{}
+	_, err = rt.RunBuild("suborbital/builder-js:v0.6.0", "/src", []string{"subo", "build", "."})
+	assert.ErrorContains(t, err, "not a util.OptionsCommandRunner")
+}
+
+func TestCLIRuntime_Args(t *testing.T) {
+	rt := &CLIRuntime{CLI: "docker", Platform: "linux/amd64", Env: map[string]string{"NPM_TOKEN": "s3cret"}}
+	cmd := []string{"subo", "build", ".", "--native", "--filter", "auth-*"}
+
+	assert.Equal(t, []string{
+		"docker", "run", "--rm", "--mount", "type=bind,source=/src,target=/root/module",
+		"-e", "NPM_TOKEN", "--platform", "linux/amd64", "suborbital/builder-js:v0.6.0",
+		"subo", "build", ".", "--native", "--filter", "auth-*",
+	}, rt.Args("suborbital/builder-js:v0.6.0", "/src", cmd))
+
+	assert.Contains(t, rt.Command("suborbital/builder-js:v0.6.0", "/src", cmd), `--filter 'auth-*'`)
+}
+
+func TestCLIRuntime_CommandQuoting(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	dir := t.TempDir()
+	args := []string{"$(touch x)", "`touch y`", "it's", "$HOME"}
+
+	// echo stands in for the container CLI, printing the arguments it receives once the shell has parsed them.
+	rt := &CLIRuntime{CLI: "echo"}
+	cmd := rt.Command("suborbital/builder-rs:v0.6.0", "/src $(touch z)", args)
+
+	out, err := util.NewCommandLineExecutor(util.SilentOutput, nil).RunInDir(cmd, dir)
+	require.NoError(t, err)
+	assert.Equal(t, "run --rm --mount type=bind,source=/src $(touch z),target=/root/module suborbital/builder-rs:v0.6.0 $(touch x) `touch y` it's $HOME\n", out)
+
+	for _, name := range []string{"x", "y", "z"} {
+		assert.NoFileExists(t, filepath.Join(dir, name))
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"--native", "--native"},
+		{"auth-*", "'auth-*'"},
+		{"", "''"},
+		{"it's", `'it'\''s'`},
+		{"café", "'café'"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, shellQuote(tt.arg), tt.arg)
+	}
+}
+
+func TestCLIRuntime_CheckAvailable(t *testing.T) {
+	runner := &recordingRunner{}
+
+	assert.NoError(t, (&CLIRuntime{CLI: "podman", Runner: runner}).CheckAvailable())
+	assert.Equal(t, []string{"podman info"}, runner.cmds, "the runtime's own CLI is checked with its runner")
+
+	err := (&CLIRuntime{CLI: "nerdctl", Runner: &flakyRunner{failures: 1}}).CheckAvailable()
+	assert.ErrorContains(t, err, "nerdctl is not installed or is not able to run containers")
+}
@@ -3,6 +3,7 @@ package builder
 import (
 	"fmt"
 	"runtime"
+	"strings"
 )
 
 // NativeBuildCommands returns the native build commands needed to build a module of a particular language.
@@ -17,67 +18,100 @@ func NativeBuildCommands(lang string) ([]string, error) {
 	return cmds, nil
 }
 
+// buildFlagsArg returns a module's build flags for use in a build command, each preceded by a space.
+// Flags are single-quoted so that they are passed to the build tool verbatim and are never interpreted by the shell.
+func buildFlagsArg(flags []string) string {
+	arg := &strings.Builder{}
+
+	for _, flag := range flags {
+		arg.WriteString(" ")
+		arg.WriteString(singleQuote(flag))
+	}
+
+	return arg.String()
+}
+
 var nativeCommandsForLang = map[string]map[string][]string{
 	"darwin": {
 		"rust": {
-			"cargo vendor && cargo build --target wasm32-wasi --lib --release",
+			"cargo vendor && cargo build --target wasm32-wasi --lib --release{{ buildFlags }}",
 			"cp target/wasm32-wasi/release/{{ .UnderscoreName }}.wasm ./{{ .Name }}.wasm",
 		},
 		"swift": {
-			"xcrun --toolchain swiftwasm swift build --triple wasm32-unknown-wasi -Xlinker --allow-undefined -Xlinker --export=allocate -Xlinker --export=deallocate -Xlinker --export=run_e -Xlinker --export=init",
+			"xcrun --toolchain swiftwasm swift build --triple wasm32-unknown-wasi -Xlinker --allow-undefined -Xlinker --export=allocate -Xlinker --export=deallocate -Xlinker --export=run_e -Xlinker --export=init{{ buildFlags }}",
 			"cp .build/debug/{{ .Name }}.wasm .",
 		},
 		"assemblyscript": {
-			"npm run asbuild",
+			"npm run asbuild{{ with buildFlags }} --{{ . }}{{ end }}",
 		},
 		"tinygo": {
 			"go get -d",
 			"go mod tidy",
-			"tinygo build -o {{ .Name }}.wasm -target wasi .",
+			"tinygo build{{ buildFlags }} -o {{ .Name }}.wasm -target wasi .",
+		},
+		"go": {
+			"go mod tidy",
+			"GOOS=wasip1 GOARCH=wasm go build{{ buildFlags }} -o {{ .Name }}.wasm .",
+		},
+		"zig": {
+			"zig build-exe src/main.zig -target wasm32-wasi -O ReleaseSmall{{ buildFlags }} -femit-bin={{ .Name }}.wasm",
+		},
+		"c": {
+			"emcc src/*.c -O3 -s STANDALONE_WASM=1 --no-entry{{ buildFlags }} -o {{ .Name }}.wasm",
 		},
 		"grain": {
-			"grain compile index.gr -I _lib -o {{ .Name }}.wasm",
+			"grain compile index.gr -I _lib{{ buildFlags }} -o {{ .Name }}.wasm",
 		},
 		"typescript": {
-			"npm run build",
+			"npm run build{{ with buildFlags }} --{{ . }}{{ end }}",
 		},
 		"javascript": {
-			"npm run build",
+			"npm run build{{ with buildFlags }} --{{ . }}{{ end }}",
 		},
 		"wat": {
-			"wat2wasm lib.wat -o {{ .Name }}.wasm",
+			"wat2wasm lib.wat{{ buildFlags }} -o {{ .Name }}.wasm",
 		},
 	},
 	"linux": {
 		"rust": {
-			"cargo vendor && cargo build --target wasm32-wasi --lib --release",
+			"cargo vendor && cargo build --target wasm32-wasi --lib --release{{ buildFlags }}",
 			"cp target/wasm32-wasi/release/{{ .UnderscoreName }}.wasm ./{{ .Name }}.wasm",
 		},
 		"swift": {
-			"swift build --triple wasm32-unknown-wasi -Xlinker --allow-undefined -Xlinker --export=allocate -Xlinker --export=deallocate -Xlinker --export=run_e -Xlinker --export=init",
+			"swift build --triple wasm32-unknown-wasi -Xlinker --allow-undefined -Xlinker --export=allocate -Xlinker --export=deallocate -Xlinker --export=run_e -Xlinker --export=init{{ buildFlags }}",
 			"cp .build/debug/{{ .Name }}.wasm .",
 		},
 		"assemblyscript": {
 			"chmod -R 777 ./",
 			"chmod +x ./node_modules/assemblyscript/bin/asc",
-			"./node_modules/assemblyscript/bin/asc src/index.ts --target release --use abort=src/index/abort {{ .CompilerFlags }}",
+			"./node_modules/assemblyscript/bin/asc src/index.ts --target release --use abort=src/index/abort {{ .CompilerFlags }}{{ buildFlags }}",
 		},
 		"tinygo": {
 			"go get -d",
 			"go mod tidy",
-			"tinygo build -o {{ .Name }}.wasm -target wasi .",
+			"tinygo build{{ buildFlags }} -o {{ .Name }}.wasm -target wasi .",
+		},
+		"go": {
+			"go mod tidy",
+			"GOOS=wasip1 GOARCH=wasm go build{{ buildFlags }} -o {{ .Name }}.wasm .",
+		},
+		"zig": {
+			"zig build-exe src/main.zig -target wasm32-wasi -O ReleaseSmall{{ buildFlags }} -femit-bin={{ .Name }}.wasm",
+		},
+		"c": {
+			"emcc src/*.c -O3 -s STANDALONE_WASM=1 --no-entry{{ buildFlags }} -o {{ .Name }}.wasm",
 		},
 		"grain": {
-			"grain compile index.gr -I _lib -o {{ .Name }}.wasm",
+			"grain compile index.gr -I _lib{{ buildFlags }} -o {{ .Name }}.wasm",
 		},
 		"typescript": {
-			"npm run build",
+			"npm run build{{ with buildFlags }} --{{ . }}{{ end }}",
 		},
 		"javascript": {
-			"npm run build",
+			"npm run build{{ with buildFlags }} --{{ . }}{{ end }}",
 		},
 		"wat": {
-			"wat2wasm lib.wat -o {{ .Name }}.wasm",
+			"wat2wasm lib.wat{{ buildFlags }} -o {{ .Name }}.wasm",
 		},
 	},
 }
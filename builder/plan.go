@@ -0,0 +1,231 @@
+package builder
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/util"
+)
+
+// BuildStep is a single command that a build would run.
+type BuildStep struct {
+	Module string
+	Dir    string
+	Image  string
+	// Platform is the platform that Image is run as, if it is not the host's.
+	Platform string
+	// Command is the step's command line. Build environment values are never part of it, see Env.
+	Command string
+	// Args is the step's command as a list of arguments that can be passed to exec.Command (run in Dir) without
+	// further quoting.
+	Args []string
+	// Env must be added to the environment that Command or Args is run with, formatted as KEY=value.
+	// It holds the build environment, so it may contain secrets.
+	Env []string
+}
+
+// String returns a human-readable description of the step.
+func (s BuildStep) String() string {
+	if s.Image != "" {
+		return fmt.Sprintf("[%s] (%s) %s", s.Dir, s.Image, s.Command)
+	}
+
+	return fmt.Sprintf("[%s] %s", s.Dir, s.Command)
+}
+
+// Plan returns the ordered steps that BuildWithToolchain would run for the given toolchain, without running them.
+// For the native toolchain this includes any prerequisite commands for prerequisites that are currently missing.
+// Modules that would be restored from the build cache are skipped.
+func (b *Builder) Plan(tcn Toolchain) ([]BuildStep, error) {
+	if err := b.checkToolchain(tcn); err != nil {
+		return nil, errors.Wrap(err, "failed to checkToolchain")
+	}
+
+	cached, err := b.cachedModules(tcn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to cachedModules")
+	}
+
+	steps := []BuildStep{}
+
+	dockerLangs := map[string]bool{}
+	customImageMods := []project.ModuleDir{}
+
+	for _, mod := range b.Context.Modules {
+		if !b.Context.ShouldBuild(mod) || cached[mod.Fullpath] {
+			continue
+		}
+
+		if tcn == ToolchainNative {
+			modSteps, err := b.planNativeBuildForModule(mod)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to planNativeBuildForModule %s", mod.Name)
+			}
+
+			steps = append(steps, modSteps...)
+		} else if mod.BuildImage != "" {
+			customImageMods = append(customImageMods, mod)
+		} else {
+			dockerLangs[mod.Module.Lang] = true
+		}
+	}
+
+	if tcn == ToolchainDocker {
+		langs := []string{}
+		for lang := range dockerLangs {
+			langs = append(langs, lang)
+		}
+
+		sort.Strings(langs)
+
+		for _, lang := range langs {
+			if b.langHasCachedModules(lang, cached) {
+				for _, mod := range b.Context.Modules {
+					if mod.Module.Lang != lang || mod.BuildImage != "" || !b.Context.ShouldBuild(mod) || cached[mod.Fullpath] {
+						continue
+					}
+
+					step, err := b.planDockerBuildForModule(mod)
+					if err != nil {
+						return nil, errors.Wrap(err, "failed to planDockerBuildForModule")
+					}
+
+					steps = append(steps, *step)
+				}
+
+				continue
+			}
+
+			step, err := b.planDockerBuildForLang(lang)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to planDockerBuildForLang")
+			}
+
+			steps = append(steps, *step)
+		}
+
+		for _, mod := range customImageMods {
+			step, err := b.planDockerBuildForModule(mod)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to planDockerBuildForModule")
+			}
+
+			steps = append(steps, *step)
+		}
+	}
+
+	return steps, nil
+}
+
+// PlanModule returns the ordered steps that would build a single module with the given toolchain, without running them.
+// With the Docker toolchain, modules that use their language's builder image are built alongside every other
+// module of that language, so the returned step builds all of them.
+func (b *Builder) PlanModule(mod project.ModuleDir, tcn Toolchain) ([]BuildStep, error) {
+	if err := b.checkToolchain(tcn); err != nil {
+		return nil, errors.Wrap(err, "failed to checkToolchain")
+	}
+
+	if tcn == ToolchainNative {
+		return b.planNativeBuildForModule(mod)
+	}
+
+	var step *BuildStep
+	var err error
+
+	if mod.BuildImage != "" {
+		step, err = b.planDockerBuildForModule(mod)
+	} else {
+		step, err = b.planDockerBuildForLang(mod.Module.Lang)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []BuildStep{*step}, nil
+}
+
+func (b *Builder) planDockerBuildForLang(lang string) (*BuildStep, error) {
+	img, rt, args, err := b.dockerRunForLang(lang)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dockerRunForLang")
+	}
+
+	step := &BuildStep{
+		Dir:      b.Context.MountPath,
+		Image:    img,
+		Platform: rt.Platform,
+		Command:  rt.Command(img, b.Context.MountPath, args),
+		Args:     rt.Args(img, b.Context.MountPath, args),
+		Env:      rt.Environ(),
+	}
+
+	return step, nil
+}
+
+func (b *Builder) planDockerBuildForModule(mod project.ModuleDir) (*BuildStep, error) {
+	rt, args, err := b.dockerRunForModule(mod)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dockerRunForModule")
+	}
+
+	img, err := b.imageForModule(mod)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to imageForModule")
+	}
+
+	step := &BuildStep{
+		Module:   mod.Name,
+		Dir:      b.Context.MountPath,
+		Image:    img,
+		Platform: rt.Platform,
+		Command:  rt.Command(img, b.Context.MountPath, args),
+		Args:     rt.Args(img, b.Context.MountPath, args),
+		Env:      rt.Environ(),
+	}
+
+	return step, nil
+}
+
+func (b *Builder) planNativeBuildForModule(mod project.ModuleDir) ([]BuildStep, error) {
+	steps := []BuildStep{}
+
+	missing := []Prereq{}
+	if !b.Context.SkipPrereqs {
+		var err error
+		missing, err = MissingPrereqs(mod)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to MissingPrereqs")
+		}
+	}
+
+	for _, p := range missing {
+		cmd, err := p.GetCommand(*b.Config, mod)
+		if err != nil {
+			return nil, errors.Wrap(err, "prereq.GetCommand")
+		}
+
+		steps = append(steps, BuildStep{Module: mod.Name, Dir: mod.SourceDir, Command: cmd, Args: util.CommandArgs(prereqShell(runtime.GOOS), cmd)})
+	}
+
+	if flags, err := b.analyzeForCompilerFlags(mod); err != nil {
+		return nil, errors.Wrap(err, "failed to analyzeForCompilerFlags")
+	} else if flags != "" {
+		mod.CompilerFlags = flags
+	}
+
+	cmds, err := nativeCommandsForModule(mod)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to nativeCommandsForModule")
+	}
+
+	for _, cmd := range cmds {
+		steps = append(steps, BuildStep{Module: mod.Name, Dir: mod.SourceDir, Command: cmd, Args: util.ShellArgs(cmd)})
+	}
+
+	return steps, nil
+}
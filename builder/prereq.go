@@ -1,12 +1,18 @@
 package builder
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"text/template"
 
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/release"
 )
 
 // Prereq is a pre-requisite file paired with the native command needed to acquire that file (if it's missing).
@@ -15,7 +21,12 @@ type Prereq struct {
 	Command string
 }
 
+// libVersionEnvKey is the environment variable that overrides the version of the
+// Suborbital library downloaded by prerequisite commands.
+const libVersionEnvKey = "SUBO_LIB_VERSION"
+
 // PreRequisiteCommands is a map of OS : language : preReq.
+// The commands for windows are PowerShell commands, which are run with powershell (see prereqShell).
 var PreRequisiteCommands = map[string]map[string][]Prereq{
 	"darwin": {
 		"rust":  {},
@@ -27,7 +38,7 @@ var PreRequisiteCommands = map[string]map[string][]Prereq{
 			},
 			Prereq{
 				File:    "_lib/_lib.tar.gz",
-				Command: "curl -L https://github.com/suborbital/reactr/archive/v{{ .ModuleDir.Module.APIVersion }}.tar.gz -o _lib/_lib.tar.gz",
+				Command: "curl -L https://github.com/suborbital/reactr/archive/v{{ .LibVersion }}.tar.gz -o _lib/_lib.tar.gz",
 			},
 			Prereq{
 				File:    "_lib/suborbital",
@@ -41,6 +52,9 @@ var PreRequisiteCommands = map[string]map[string][]Prereq{
 			},
 		},
 		"tinygo": {},
+		"go":     {},
+		"zig":    {},
+		"c":      {},
 		"typescript": {
 			Prereq{
 				File:    "node_modules",
@@ -65,7 +79,7 @@ var PreRequisiteCommands = map[string]map[string][]Prereq{
 			},
 			Prereq{
 				File:    "_lib/_lib.tar.gz",
-				Command: "curl -L https://github.com/suborbital/reactr/archive/v{{ .ModuleDir.Module.APIVersion }}.tar.gz -o _lib/_lib.tar.gz",
+				Command: "curl -L https://github.com/suborbital/reactr/archive/v{{ .LibVersion }}.tar.gz -o _lib/_lib.tar.gz",
 			},
 			Prereq{
 				File:    "_lib/suborbital",
@@ -79,6 +93,9 @@ var PreRequisiteCommands = map[string]map[string][]Prereq{
 			},
 		},
 		"tinygo": {},
+		"go":     {},
+		"zig":    {},
+		"c":      {},
 		"typescript": {
 			Prereq{
 				File:    "node_modules",
@@ -93,6 +110,208 @@ var PreRequisiteCommands = map[string]map[string][]Prereq{
 		},
 		"wat": {},
 	},
+	"windows": {
+		"rust":  {},
+		"swift": {},
+		"grain": {
+			Prereq{
+				File:    "_lib",
+				Command: "New-Item -ItemType Directory _lib",
+			},
+			Prereq{
+				File:    "_lib/_lib.tar.gz",
+				Command: "Invoke-WebRequest -Uri https://github.com/suborbital/reactr/archive/v{{ .LibVersion }}.tar.gz -OutFile _lib/_lib.tar.gz",
+			},
+			Prereq{
+				File:    "_lib/suborbital",
+				Command: "tar --strip-components=3 -C _lib -xvzf _lib/_lib.tar.gz **/api/grain/suborbital/*",
+			},
+		},
+		"assemblyscript": {
+			Prereq{
+				File:    "node_modules",
+				Command: "{{ .BuildConfig.JsToolchain }} install",
+			},
+		},
+		"tinygo": {},
+		"go":     {},
+		"zig":    {},
+		"c":      {},
+		"typescript": {
+			Prereq{
+				File:    "node_modules",
+				Command: "{{ .BuildConfig.JsToolchain }} install",
+			},
+		},
+		"javascript": {
+			Prereq{
+				File:    "node_modules",
+				Command: "{{ .BuildConfig.JsToolchain }} install",
+			},
+		},
+		"wat": {},
+	},
+}
+
+// PrereqsForLang returns the prerequisites for the given language on the current OS.
+func PrereqsForLang(lang string) ([]Prereq, error) {
+	preReqLangs, ok := PreRequisiteCommands[runtime.GOOS]
+	if !ok {
+		return nil, fmt.Errorf("unsupported OS: %s has no prerequisite commands defined", runtime.GOOS)
+	}
+
+	preReqs, ok := preReqLangs[strings.ToLower(lang)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s", lang)
+	}
+
+	return preReqs, nil
+}
+
+// PrereqsForModule returns the prerequisites for the module's language on the current OS,
+// followed by any additional prerequisites declared in the module's manifest.
+func PrereqsForModule(md project.ModuleDir) ([]Prereq, error) {
+	langPreReqs, err := PrereqsForLang(md.Module.Lang)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to PrereqsForLang")
+	}
+
+	preReqs := make([]Prereq, 0, len(langPreReqs)+len(md.Prereqs))
+	preReqs = append(preReqs, langPreReqs...)
+
+	for _, p := range md.Prereqs {
+		preReqs = append(preReqs, Prereq{File: p.File, Command: p.Command})
+	}
+
+	return preReqs, nil
+}
+
+// MissingPrereqs returns the prerequisites for the module whose File does not yet exist
+// in the module's source directory. Existing files and directories are both considered satisfied.
+func MissingPrereqs(md project.ModuleDir) ([]Prereq, error) {
+	preReqs, err := PrereqsForModule(md)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to PrereqsForModule")
+	}
+
+	missing := []Prereq{}
+
+	for _, p := range preReqs {
+		if _, err := os.Stat(filepath.Join(md.SourceDir, p.File)); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, errors.Wrapf(err, "failed to Stat %s", p.File)
+			}
+
+			missing = append(missing, p)
+		}
+	}
+
+	return missing, nil
+}
+
+// lookPath finds executables on PATH, and is replaced by tests.
+var lookPath = exec.LookPath
+
+// commandTool returns the executable that a prerequisite command for goos invokes, skipping leading
+// environment variable assignments. Empty is returned for PowerShell cmdlets (such as New-Item),
+// which are not found on PATH.
+func commandTool(goos, cmd string) string {
+	for _, field := range strings.Fields(cmd) {
+		if strings.Contains(field, "=") {
+			continue
+		}
+
+		if goos == "windows" && strings.Contains(field, "-") {
+			return ""
+		}
+
+		return field
+	}
+
+	return ""
+}
+
+// MissingPrereqTools returns the executables that the module's missing language prerequisites invoke but
+// that are not installed, such as curl or npm. Prerequisites declared in the module's manifest are not
+// checked, since they may run scripts relative to the module's directory.
+func MissingPrereqTools(b BuildConfig, md project.ModuleDir) ([]string, error) {
+	langPreReqs, err := PrereqsForLang(md.Module.Lang)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to PrereqsForLang")
+	}
+
+	isLangPreReq := map[Prereq]bool{}
+	for _, p := range langPreReqs {
+		isLangPreReq[p] = true
+	}
+
+	missing, err := MissingPrereqs(md)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to MissingPrereqs")
+	}
+
+	tools := []string{}
+	seen := map[string]bool{}
+
+	for _, p := range missing {
+		if !isLangPreReq[p] {
+			continue
+		}
+
+		cmd, err := p.GetCommand(b, md)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to GetCommand")
+		}
+
+		tool := commandTool(runtime.GOOS, cmd)
+		if tool == "" || seen[tool] {
+			continue
+		}
+
+		seen[tool] = true
+
+		if _, err := lookPath(tool); err != nil {
+			tools = append(tools, tool)
+		}
+	}
+
+	return tools, nil
+}
+
+// ExpandedPrereqsForModule returns the prerequisites for the module,
+// with each Command's template executed for the module.
+func ExpandedPrereqsForModule(b BuildConfig, md project.ModuleDir) ([]Prereq, error) {
+	preReqs, err := PrereqsForModule(md)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to PrereqsForModule")
+	}
+
+	expanded := make([]Prereq, len(preReqs))
+
+	for i, p := range preReqs {
+		cmd, err := p.GetCommand(b, md)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to GetCommand")
+		}
+
+		expanded[i] = Prereq{File: p.File, Command: cmd}
+	}
+
+	return expanded, nil
+}
+
+// LibVersionForModule returns the version of the Suborbital library that prerequisite commands download for the module.
+// $SUBO_LIB_VERSION takes precedence, followed by the module's apiVersion, falling back to release.ReactrVersion.
+func LibVersionForModule(md project.ModuleDir) string {
+	if envVersion, exists := os.LookupEnv(libVersionEnvKey); exists && envVersion != "" {
+		return envVersion
+	}
+
+	if md.Module != nil && md.Module.APIVersion != "" {
+		return md.Module.APIVersion
+	}
+
+	return release.ReactrVersion
 }
 
 // GetCommand takes a ModuleDir, and returns an executed template command string.
@@ -103,12 +322,14 @@ func (p Prereq) GetCommand(b BuildConfig, md project.ModuleDir) (string, error)
 	}
 
 	type TemplateParams struct {
-		ModuleDir project.ModuleDir
+		ModuleDir  project.ModuleDir
+		LibVersion string
 		BuildConfig
 	}
 
 	data := TemplateParams{
 		ModuleDir:   md,
+		LibVersion:  LibVersionForModule(md),
 		BuildConfig: b,
 	}
 
@@ -120,3 +341,13 @@ func (p Prereq) GetCommand(b BuildConfig, md project.ModuleDir) (string, error)
 
 	return fullCmd.String(), nil
 }
+
+// prereqShell returns the shell that prerequisite commands are run with on goos (see util.RunOptions.Shell),
+// powershell on windows and sh (nil) everywhere else.
+func prereqShell(goos string) []string {
+	if goos == "windows" {
+		return []string{"powershell", "-NoProfile", "-NonInteractive", "-Command"}
+	}
+
+	return nil
+}
@@ -1,11 +1,21 @@
 package builder
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/release"
+	"github.com/suborbital/subo/subo/util"
 	"github.com/suborbital/systemspec/tenant"
 )
 
@@ -67,3 +77,260 @@ func TestPrereq_GetCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestPrereqShell(t *testing.T) {
+	cmd := "Invoke-WebRequest -Uri https://example.com/lib.tar.gz -OutFile _lib/_lib.tar.gz"
+
+	assert.Equal(t, []string{"sh", "-c", cmd}, util.CommandArgs(prereqShell("linux"), cmd))
+	assert.Equal(t, []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", cmd}, util.CommandArgs(prereqShell("windows"), cmd))
+
+	// a runner that can't be given a shell runs the command line that passes the command to it.
+	runner := &recordingRunner{}
+	_, err := runWithOptions(plainRunner{runner}, "Write-Output 'hi'", "", util.RunOptions{Shell: prereqShell("windows")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`powershell -NoProfile -NonInteractive -Command 'Write-Output '\''hi'\'''`}, runner.cmds)
+}
+
+func TestCommandTool(t *testing.T) {
+	assert.Equal(t, "curl", commandTool("linux", "curl -L https://example.com -o _lib/_lib.tar.gz"))
+	assert.Equal(t, "npm", commandTool("linux", "NODE_ENV=production npm install"))
+	assert.Equal(t, "tar", commandTool("windows", "tar -xvzf _lib/_lib.tar.gz -C _lib"))
+	assert.Equal(t, "", commandTool("windows", "New-Item -Path _lib -ItemType directory"), "cmdlets aren't on PATH")
+}
+
+func TestPrereqsForLang(t *testing.T) {
+	tests := []struct {
+		name    string
+		lang    string
+		wantLen int
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "resolves a known language",
+			lang:    "grain",
+			wantLen: 3,
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "normalizes language casing",
+			lang:    "AssemblyScript",
+			wantLen: 1,
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "errors for an unknown language",
+			lang:    "cobol",
+			wantLen: 0,
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PrereqsForLang(tt.lang)
+
+			tt.wantErr(t, err)
+			assert.Len(t, got, tt.wantLen)
+		})
+	}
+}
+
+func TestLibVersionForModule(t *testing.T) {
+	md := project.ModuleDir{Module: &tenant.Module{APIVersion: "0.33.75"}}
+
+	assert.Equal(t, "0.33.75", LibVersionForModule(md))
+	assert.Equal(t, release.ReactrVersion, LibVersionForModule(project.ModuleDir{Module: &tenant.Module{}}))
+
+	t.Setenv(libVersionEnvKey, "1.2.3")
+	assert.Equal(t, "1.2.3", LibVersionForModule(md))
+}
+
+func TestPrereqsForModule(t *testing.T) {
+	md := project.ModuleDir{
+		Module: &tenant.Module{Lang: "assemblyscript"},
+		Prereqs: []project.ModulePrereq{
+			{File: "assembly/generated", Command: "npm run codegen"},
+		},
+	}
+
+	got, err := PrereqsForModule(md)
+	assert.NoError(t, err)
+	assert.Equal(t, []Prereq{
+		{File: "node_modules", Command: "{{ .BuildConfig.JsToolchain }} install"},
+		{File: "assembly/generated", Command: "npm run codegen"},
+	}, got)
+}
+
+// recordingRunner records the commands it runs and the environment and stdin they are run with, failing any that
+// contain "fail".
+type recordingRunner struct {
+	lock   sync.Mutex
+	cmds   []string
+	envs   [][]string
+	stdins []string
+}
+
+func (r *recordingRunner) Run(cmd string) (string, error) {
+	return r.RunInDir(cmd, "")
+}
+
+func (r *recordingRunner) RunInDir(cmd, dir string) (string, error) {
+	return r.RunInDirWithOptions(context.Background(), cmd, dir, util.RunOptions{})
+}
+
+func (r *recordingRunner) RunInDirContext(ctx context.Context, cmd, dir string) (string, error) {
+	return r.RunInDirWithOptions(ctx, cmd, dir, util.RunOptions{})
+}
+
+func (r *recordingRunner) RunInDirWithOptions(ctx context.Context, cmd, dir string, opts util.RunOptions) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cmds = append(r.cmds, cmd)
+	r.envs = append(r.envs, opts.Env)
+
+	stdin := ""
+	if opts.Stdin != nil {
+		stdinBytes, err := ioutil.ReadAll(opts.Stdin)
+		if err != nil {
+			return "", err
+		}
+
+		stdin = string(stdinBytes)
+	}
+
+	r.stdins = append(r.stdins, stdin)
+	if strings.Contains(cmd, "fail") {
+		return "", errors.New("command failed")
+	}
+
+	return "ran " + cmd, nil
+}
+
+// plainRunner hides every method of the runner it wraps other than those of util.CommandRunner.
+type plainRunner struct {
+	runner util.CommandRunner
+}
+
+func (p plainRunner) Run(cmd string) (string, error) { return p.runner.Run(cmd) }
+
+func (p plainRunner) RunInDir(cmd, dir string) (string, error) { return p.runner.RunInDir(cmd, dir) }
+
+func TestBuilder_RunPrereqs(t *testing.T) {
+	modWithPrereq := func(name, cmd string) project.ModuleDir {
+		return project.ModuleDir{
+			Name:      name,
+			Fullpath:  "/" + name,
+			SourceDir: t.TempDir(),
+			Module:    &tenant.Module{Name: name, Lang: "rust"},
+			Prereqs:   []project.ModulePrereq{{File: "generated", Command: cmd}},
+		}
+	}
+
+	runner := &recordingRunner{}
+	b := &Builder{
+		Context: &project.Context{Modules: []project.ModuleDir{
+			modWithPrereq("one", "codegen one"),
+			modWithPrereq("two", "fail two"),
+			modWithPrereq("three", "codegen three"),
+		}},
+		Config: &BuildConfig{CommandRunner: runner, PrereqConcurrency: 2},
+		log:    &util.PrintLogger{},
+	}
+
+	results, err := b.RunPrereqs()
+	assert.ErrorContains(t, err, "two")
+	assert.NotContains(t, err.Error(), "one")
+	assert.ElementsMatch(t, []string{"codegen one", "fail two", "codegen three"}, runner.cmds)
+	assert.Contains(t, results["/one"].OutputLog, "ran codegen one")
+}
+
+func TestBuilder_RunPrereqs_Skip(t *testing.T) {
+	runner := &recordingRunner{}
+	b := &Builder{
+		Context: &project.Context{
+			SkipPrereqs: true,
+			Modules: []project.ModuleDir{{
+				Name:      "one",
+				Fullpath:  "/one",
+				SourceDir: t.TempDir(),
+				Module:    &tenant.Module{Name: "one", Lang: "assemblyscript"},
+			}},
+		},
+		Config: &BuildConfig{CommandRunner: runner},
+		log:    &util.PrintLogger{},
+	}
+
+	results, err := b.RunPrereqs()
+	assert.NoError(t, err)
+	assert.Empty(t, runner.cmds)
+	assert.NotNil(t, results["/one"])
+}
+
+func TestBuilder_CheckPrereqTools(t *testing.T) {
+	// only mkdir, curl and tar are installed.
+	lookPath = func(file string) (string, error) {
+		switch file {
+		case "mkdir", "curl", "tar":
+			return "/usr/bin/" + file, nil
+		}
+
+		return "", exec.ErrNotFound
+	}
+	t.Cleanup(func() { lookPath = exec.LookPath })
+
+	installed := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(installed, "node_modules"), util.PermDirectory))
+
+	mod := func(name, sourceDir string, prereqs ...project.ModulePrereq) project.ModuleDir {
+		return project.ModuleDir{
+			Name:      name,
+			SourceDir: sourceDir,
+			Module:    &tenant.Module{Name: name, Lang: "assemblyscript"},
+			Prereqs:   prereqs,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		skip    bool
+		modules []project.ModuleDir
+		wantErr string
+	}{
+		{
+			name:    "missing tool",
+			modules: []project.ModuleDir{mod("one", t.TempDir()), mod("two", t.TempDir())},
+			wantErr: "please install npm (needed by one, two)",
+		},
+		{
+			name:    "prereq already satisfied",
+			modules: []project.ModuleDir{mod("one", installed)},
+		},
+		{
+			name:    "module prereqs are not checked",
+			modules: []project.ModuleDir{mod("one", installed, project.ModulePrereq{File: "generated", Command: "./codegen.sh"})},
+		},
+		{
+			name:    "prereqs skipped",
+			skip:    true,
+			modules: []project.ModuleDir{mod("one", t.TempDir())},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Builder{
+				Context: &project.Context{SkipPrereqs: tt.skip, Modules: tt.modules},
+				Config:  &BuildConfig{JsToolchain: "npm"},
+				log:     &util.PrintLogger{},
+			}
+
+			err := b.CheckPrereqTools()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
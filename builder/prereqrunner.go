@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/project"
+)
+
+// RunPrereqs runs the missing prerequisites of every module that will be built, returning the result of each
+// keyed by the module's Fullpath. Each module's prerequisites run in order, but different modules run concurrently,
+// at most BuildConfig.PrereqConcurrency at a time (runtime.GOMAXPROCS if not set). Every module is attempted,
+// and the failures of all of them are reported in the returned error. Nothing is run if Context.SkipPrereqs is set,
+// or if a tool needed by the prerequisites is not installed (see CheckPrereqTools).
+func (b *Builder) RunPrereqs() (map[string]*BuildResult, error) {
+	if err := b.CheckPrereqTools(); err != nil {
+		return nil, err
+	}
+
+	limit := b.Config.PrereqConcurrency
+	if limit < 1 {
+		limit = runtime.GOMAXPROCS(0)
+	}
+
+	results := map[string]*BuildResult{}
+	failed := []string{}
+
+	lock := sync.Mutex{}
+	sem := make(chan struct{}, limit)
+	wg := sync.WaitGroup{}
+
+	for _, mod := range b.Context.Modules {
+		if !b.shouldBuild(mod) {
+			continue
+		}
+
+		result := &BuildResult{}
+		results[mod.Fullpath] = result
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(mod project.ModuleDir, result *BuildResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := b.checkAndRunPreReqs(mod, result); err != nil {
+				lock.Lock()
+				failed = append(failed, fmt.Sprintf("%s: %s", mod.Name, err.Error()))
+				lock.Unlock()
+			}
+		}(mod, result)
+	}
+
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return results, errors.Errorf("failed to run prereqs for %d module(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+
+	return results, nil
+}
+
+// CheckPrereqTools returns an error naming every tool that the missing prerequisites of the modules
+// that will be built need but that is not installed, so that they fail before any prerequisite runs.
+func (b *Builder) CheckPrereqTools() error {
+	if b.Context.SkipPrereqs {
+		return nil
+	}
+
+	// neededBy maps each missing tool to the modules that need it.
+	neededBy := map[string][]string{}
+
+	for _, mod := range b.Context.Modules {
+		if !b.shouldBuild(mod) {
+			continue
+		}
+
+		tools, err := MissingPrereqTools(*b.Config, mod)
+		if err != nil {
+			return errors.Wrapf(err, "failed to MissingPrereqTools for %s", mod.Name)
+		}
+
+		for _, tool := range tools {
+			neededBy[tool] = append(neededBy[tool], mod.Name)
+		}
+	}
+
+	if len(neededBy) == 0 {
+		return nil
+	}
+
+	missing := make([]string, 0, len(neededBy))
+	for tool, mods := range neededBy {
+		missing = append(missing, fmt.Sprintf("%s (needed by %s)", tool, strings.Join(mods, ", ")))
+	}
+
+	sort.Strings(missing)
+
+	return fmt.Errorf("please install %s, which the prerequisites of modules being built need", strings.Join(missing, " and "))
+}
@@ -0,0 +1,186 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+const (
+	// imagePullTimeoutEnvKey is the environment variable that overrides BuildConfig.ImagePullTimeout.
+	imagePullTimeoutEnvKey = "SUBO_IMAGE_PULL_TIMEOUT"
+
+	// registryUsernameEnvKey and registryPasswordEnvKey are the credentials used to log in to
+	// the builder registry ($SUBO_BUILDER_REGISTRY) before pulling builder images.
+	registryUsernameEnvKey = "SUBO_REGISTRY_USERNAME"
+	registryPasswordEnvKey = "SUBO_REGISTRY_PASSWORD"
+)
+
+// DefaultImagePullTimeout is how long pulling a single builder image may take.
+const DefaultImagePullTimeout = 10 * time.Minute
+
+// HasImage returns true if image is already present locally.
+func (c *CLIRuntime) HasImage(image string) bool {
+	_, err := runWithOptions(c.Runner, fmt.Sprintf("%s image inspect %s", c.CLI, shellQuote(image)), "", util.RunOptions{Silent: true})
+
+	return err == nil
+}
+
+// VerifyImageDigest returns an error if the local copy of image was not pulled with the given digest (such as sha256:...).
+func (c *CLIRuntime) VerifyImageDigest(image, digest string) error {
+	cmd := fmt.Sprintf("%s image inspect --format '{{ join .RepoDigests \"\\n\" }}' %s", c.CLI, shellQuote(image))
+
+	out, err := runWithOptions(c.Runner, cmd, "", util.RunOptions{Silent: true})
+	if err != nil {
+		return errors.Wrapf(err, "failed to inspect %s", image)
+	}
+
+	if !repoDigestsContain(out, digest) {
+		return fmt.Errorf("the local copy of %s does not match digest %s, remove it and pull it again", image, digest)
+	}
+
+	return nil
+}
+
+// repoDigestsContain returns true if one of the newline-separated repo digests (such as
+// suborbital/builder-rs@sha256:...) printed by `image inspect` has the given digest.
+func repoDigestsContain(repoDigests, digest string) bool {
+	for _, repoDigest := range strings.Split(repoDigests, "\n") {
+		if strings.HasSuffix(strings.TrimSpace(repoDigest), "@"+digest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pull pulls image, failing with an error wrapping ErrCommandTimeout if it takes longer than timeout.
+func (c *CLIRuntime) Pull(image string, timeout time.Duration) error {
+	cmd := fmt.Sprintf("%s pull %s", c.CLI, shellQuote(image))
+	if c.Platform != "" {
+		cmd = fmt.Sprintf("%s pull --platform %s %s", c.CLI, shellQuote(c.Platform), shellQuote(image))
+	}
+
+	if _, err := RunInDirWithTimeout(c.Runner, cmd, "", util.RunOptions{}, timeout); err != nil {
+		return errors.Wrapf(err, "failed to %s", cmd)
+	}
+
+	return nil
+}
+
+// Login logs in to registry using $SUBO_REGISTRY_USERNAME and $SUBO_REGISTRY_PASSWORD, if they are set.
+// The password is passed on stdin so that it never appears in a command line.
+func (c *CLIRuntime) Login(registry string) error {
+	username, hasUsername := os.LookupEnv(registryUsernameEnvKey)
+	password, hasPassword := os.LookupEnv(registryPasswordEnvKey)
+
+	if !hasUsername || !hasPassword {
+		return nil
+	}
+
+	cmd := fmt.Sprintf("%s login %s --username %s --password-stdin", c.CLI, shellQuote(registry), shellQuote(username))
+	if _, err := runWithOptions(c.Runner, cmd, "", util.RunOptions{Stdin: strings.NewReader(password)}); err != nil {
+		return errors.Wrapf(err, "failed to log in to %s", registry)
+	}
+
+	return nil
+}
+
+// PullImages pulls each builder image needed to build the context's modules with the Docker toolchain that is
+// not already present locally, logging in to the builder registry first if credentials are provided.
+// Modules that would be restored from the build cache don't need their builder image.
+// Each pull is limited to the config's ImagePullTimeout, or $SUBO_IMAGE_PULL_TIMEOUT if it is set.
+func (b *Builder) PullImages() error {
+	timeout, err := b.imagePullTimeout()
+	if err != nil {
+		return errors.Wrap(err, "failed to get image pull timeout")
+	}
+
+	steps, err := b.Plan(ToolchainDocker)
+	if err != nil {
+		return errors.Wrap(err, "failed to Plan")
+	}
+
+	platforms := map[string]string{}
+	for _, step := range steps {
+		platforms[step.Image] = step.Platform
+	}
+
+	images := make([]string, 0, len(platforms))
+	for img := range platforms {
+		images = append(images, img)
+	}
+
+	sort.Strings(images)
+
+	rt, err := NewContainerRuntime(b.Config.CommandRunner)
+	if err != nil {
+		return errors.Wrap(err, "failed to NewContainerRuntime")
+	}
+
+	loggedIn := false
+
+	for _, img := range images {
+		rt.Platform = platforms[img]
+
+		if rt.HasImage(img) {
+			continue
+		}
+
+		if !loggedIn && b.Context.BuilderRegistry != "" {
+			if err := rt.Login(b.Context.BuilderRegistry); err != nil {
+				return errors.Wrap(err, "failed to Login")
+			}
+
+			loggedIn = true
+		}
+
+		b.log.LogStart(fmt.Sprintf("pulling builder image %s", img))
+
+		if err := rt.Pull(img, timeout); err != nil {
+			if errors.Is(err, ErrCommandTimeout) {
+				return errors.Wrapf(err, "pulling builder image %s timed out, check your network or proxy settings or set $%s", img, imagePullTimeoutEnvKey)
+			}
+
+			return errors.Wrapf(err, "failed to pull builder image %s, check that it exists and that you are logged in to its registry", img)
+		}
+
+		b.log.LogDone(fmt.Sprintf("pulled %s", img))
+	}
+
+	// images pinned to a digest are checked before being trusted to build with.
+	for _, img := range images {
+		if at := strings.LastIndex(img, "@"); at >= 0 {
+			if err := rt.VerifyImageDigest(img, img[at+1:]); err != nil {
+				return errors.Wrap(err, "failed to VerifyImageDigest")
+			}
+		}
+	}
+
+	return nil
+}
+
+// imagePullTimeout returns the config's ImagePullTimeout (DefaultImagePullTimeout if it is 0),
+// unless it is overridden by $SUBO_IMAGE_PULL_TIMEOUT.
+func (b *Builder) imagePullTimeout() (time.Duration, error) {
+	if envTimeout, exists := os.LookupEnv(imagePullTimeoutEnvKey); exists && envTimeout != "" {
+		timeout, err := time.ParseDuration(envTimeout)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to parse %s", imagePullTimeoutEnvKey)
+		}
+
+		return timeout, nil
+	}
+
+	if b.Config.ImagePullTimeout == 0 {
+		return DefaultImagePullTimeout, nil
+	}
+
+	return b.Config.ImagePullTimeout, nil
+}
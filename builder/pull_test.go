@@ -0,0 +1,74 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLIRuntime_PullAndLogin(t *testing.T) {
+	runner := &recordingRunner{}
+	rt := &CLIRuntime{CLI: "docker", Platform: "linux/amd64", Runner: runner}
+
+	require.NoError(t, rt.Pull("suborbital/builder-swift:v0.6.0", time.Minute))
+
+	t.Setenv(registryUsernameEnvKey, "")
+	require.NoError(t, rt.Login("registry.example.com"))
+
+	t.Setenv(registryPasswordEnvKey, "s3cret")
+	t.Setenv(registryUsernameEnvKey, "ci-bot")
+	require.NoError(t, rt.Login("registry.example.com"))
+
+	require.Len(t, runner.cmds, 2)
+	assert.Equal(t, "docker pull --platform linux/amd64 suborbital/builder-swift:v0.6.0", runner.cmds[0])
+	assert.Equal(t, "docker login registry.example.com --username ci-bot --password-stdin", runner.cmds[1])
+	assert.Equal(t, "s3cret", runner.stdins[1], "the password is passed on stdin")
+	assert.NotContains(t, runner.cmds[1], "s3cret")
+
+	// the image and platform come from configuration, so they are quoted like any other argument.
+	rt.Platform = "linux/amd64;id"
+	require.NoError(t, rt.Pull("example.com/builder rs", time.Minute))
+	assert.Equal(t, "docker pull --platform 'linux/amd64;id' 'example.com/builder rs'", runner.cmds[2])
+}
+
+func TestCLIRuntime_InspectUsesRunner(t *testing.T) {
+	runner := &recordingRunner{}
+	rt := &CLIRuntime{CLI: "podman", Runner: runner}
+
+	assert.True(t, rt.HasImage("suborbital/builder-rs:v0.6.0"))
+	assert.False(t, rt.HasImage("suborbital/builder-fail:v0.6.0"))
+
+	err := rt.VerifyImageDigest("suborbital/builder-rs:v0.6.0", "sha256:abc")
+	assert.ErrorContains(t, err, "does not match digest")
+
+	require.Len(t, runner.cmds, 3)
+	for _, cmd := range runner.cmds {
+		assert.True(t, strings.HasPrefix(cmd, "podman image inspect "), cmd)
+	}
+}
+
+func TestBuilder_ImagePullTimeout(t *testing.T) {
+	b := &Builder{Config: &BuildConfig{}}
+
+	timeout, err := b.imagePullTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultImagePullTimeout, timeout)
+
+	t.Setenv(imagePullTimeoutEnvKey, "30s")
+
+	timeout, err = b.imagePullTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+}
+
+func TestRepoDigestsContain(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	repoDigests := "suborbital/builder-rs@sha256:" + strings.Repeat("b", 64) + "\nregistry.example.com/suborbital/builder-rs@" + digest + "\n"
+
+	assert.True(t, repoDigestsContain(repoDigests, digest))
+	assert.False(t, repoDigestsContain(repoDigests, "sha256:"+strings.Repeat("c", 64)))
+	assert.False(t, repoDigestsContain("", digest), "images built locally have no repo digests")
+}
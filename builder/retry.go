@@ -0,0 +1,149 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+// RetryPolicy controls how many times a failing prerequisite command is attempted.
+type RetryPolicy struct {
+	// Attempts is the maximum number of times a command is run, values less than 1 run it once.
+	Attempts int
+	// BaseDelay is the delay before the first retry, doubling after each subsequent failure.
+	BaseDelay time.Duration
+	// NetworkOnly limits retries to commands that fetch something over the network.
+	NetworkOnly bool
+	// Timeout is how long each attempt may run before it is killed, attempts never time out if it is 0.
+	// $SUBO_PREREQ_TIMEOUT (such as "10m") takes precedence when it is set.
+	Timeout time.Duration
+}
+
+// DefaultRetryPolicy retries network fetches a few times to ride out transient failures.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:    3,
+	BaseDelay:   time.Second,
+	NetworkOnly: true,
+	Timeout:     5 * time.Minute,
+}
+
+// prereqTimeoutEnvKey is the environment variable that overrides RetryPolicy.Timeout.
+const prereqTimeoutEnvKey = "SUBO_PREREQ_TIMEOUT"
+
+// ErrCommandTimeout is returned when a command runs for longer than its timeout.
+var ErrCommandTimeout = errors.New("command timed out")
+
+// sleep is swapped out in tests to avoid waiting between attempts.
+var sleep = time.Sleep
+
+// RunInDirWithRetry runs cmd in dir using runner with opts, retrying with exponential backoff according to policy.
+// A command that times out is not retried. The output of the final attempt is returned.
+func RunInDirWithRetry(runner util.CommandRunner, cmd, dir string, opts util.RunOptions, policy RetryPolicy) (string, error) {
+	attempts := policy.Attempts
+	if attempts < 1 || (policy.NetworkOnly && !isNetworkCommand(cmd)) {
+		attempts = 1
+	}
+
+	timeout, err := policy.timeout()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get timeout")
+	}
+
+	delay := policy.BaseDelay
+
+	var outputLog string
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			sleep(delay)
+			delay *= 2
+		}
+
+		outputLog, err = RunInDirWithTimeout(runner, cmd, dir, opts, timeout)
+		if err == nil {
+			return outputLog, nil
+		}
+
+		// a command that hung once is likely to hang again, so it isn't retried.
+		if errors.Is(err, ErrCommandTimeout) {
+			attempts = i + 1
+			break
+		}
+	}
+
+	return outputLog, errors.Wrapf(err, "failed after %d attempt(s)", attempts)
+}
+
+// timeout returns the policy's Timeout, unless it is overridden by $SUBO_PREREQ_TIMEOUT.
+func (p RetryPolicy) timeout() (time.Duration, error) {
+	envTimeout, exists := os.LookupEnv(prereqTimeoutEnvKey)
+	if !exists || envTimeout == "" {
+		return p.Timeout, nil
+	}
+
+	timeout, err := time.ParseDuration(envTimeout)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", prereqTimeoutEnvKey)
+	}
+
+	return timeout, nil
+}
+
+// RunInDirWithTimeout runs cmd in dir using runner with opts (see runWithOptions), returning an error wrapping ErrCommandTimeout if it runs for
+// longer than timeout, or running it without a time limit if timeout is 0. A command is only killed at the timeout
+// when runner is a util.ContextCommandRunner, any other runner's command is left to finish in the background.
+func RunInDirWithTimeout(runner util.CommandRunner, cmd, dir string, opts util.RunOptions, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return runWithOptions(runner, cmd, dir, opts)
+	}
+
+	if _, ok := runner.(util.ContextCommandRunner); !ok {
+		return runInDirWithTimer(runner, cmd, dir, opts, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	outputLog, err := runWithOptionsContext(ctx, runner, cmd, dir, opts)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return outputLog, errors.Wrapf(ErrCommandTimeout, "killed after %s", timeout)
+	}
+
+	return outputLog, err
+}
+
+// runInDirWithTimer runs cmd in dir using a runner that can't kill commands, giving up on it after timeout.
+func runInDirWithTimer(runner util.CommandRunner, cmd, dir string, opts util.RunOptions, timeout time.Duration) (string, error) {
+	type result struct {
+		outputLog string
+		err       error
+	}
+
+	// buffered so that a command that outlives the timeout doesn't leak its goroutine when it finishes.
+	done := make(chan result, 1)
+
+	go func() {
+		outputLog, err := runWithOptions(runner, cmd, dir, opts)
+		done <- result{outputLog, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.outputLog, res.err
+	case <-timer.C:
+		return "", errors.Wrapf(ErrCommandTimeout, "gave up after %s", timeout)
+	}
+}
+
+// isNetworkCommand returns true if the command appears to fetch something over the network.
+func isNetworkCommand(cmd string) bool {
+	return strings.Contains(cmd, "://")
+}
@@ -0,0 +1,151 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+// flakyRunner fails until it has been run failures+1 times.
+// If hangs is set, each run instead blocks until its context is done.
+type flakyRunner struct {
+	failures int
+	hangs    bool
+	runs     int
+}
+
+func (f *flakyRunner) Run(cmd string) (string, error) {
+	return f.RunInDir(cmd, "")
+}
+
+func (f *flakyRunner) RunInDir(cmd, dir string) (string, error) {
+	f.runs++
+	if f.runs <= f.failures {
+		return "", errors.New("transient failure")
+	}
+
+	return "ok", nil
+}
+
+func (f *flakyRunner) RunInDirContext(ctx context.Context, cmd, dir string) (string, error) {
+	if f.hangs {
+		f.runs++
+		<-ctx.Done()
+
+		return "", ctx.Err()
+	}
+
+	return f.RunInDir(cmd, dir)
+}
+
+func TestRunInDirWithRetry(t *testing.T) {
+	delays := []time.Duration{}
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { sleep = time.Sleep })
+
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Second, NetworkOnly: true, Timeout: 10 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		cmd      string
+		failures int
+		hangs    bool
+		wantRuns int
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			name:     "retries a network fetch until it succeeds",
+			cmd:      "curl -L https://example.com/lib.tar.gz -o lib.tar.gz",
+			failures: 2,
+			wantRuns: 3,
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "gives up after the maximum attempts",
+			cmd:      "curl -L https://example.com/lib.tar.gz -o lib.tar.gz",
+			failures: 5,
+			wantRuns: 3,
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "does not retry a network fetch that timed out",
+			cmd:      "curl -L https://example.com/lib.tar.gz -o lib.tar.gz",
+			hangs:    true,
+			wantRuns: 1,
+			wantErr: func(t assert.TestingT, err error, _ ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrCommandTimeout)
+			},
+		},
+		{
+			name:     "does not retry a local command",
+			cmd:      "mkdir _lib",
+			failures: 1,
+			wantRuns: 1,
+			wantErr:  assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays = []time.Duration{}
+			runner := &flakyRunner{failures: tt.failures, hangs: tt.hangs}
+
+			_, err := RunInDirWithRetry(runner, tt.cmd, "", util.RunOptions{}, policy)
+
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.wantRuns, runner.runs)
+			if tt.wantRuns == 3 {
+				assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, delays)
+			}
+		})
+	}
+}
+
+func TestRunInDirWithTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	_, err := RunInDirWithTimeout(util.Command, "exec sleep 5", t.TempDir(), util.RunOptions{}, 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+
+	out, err := RunInDirWithTimeout(util.NewCommandLineExecutor(util.SilentOutput, nil), "echo hi", t.TempDir(), util.RunOptions{}, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi\n", out)
+
+	out, err = RunInDirWithTimeout(noContextRunner{}, "echo hi", t.TempDir(), util.RunOptions{}, time.Second)
+	assert.NoError(t, err, "a runner that can't kill commands still runs them with a timeout")
+	assert.Equal(t, "ok", out)
+
+	_, err = RunInDirWithTimeout(noContextRunner{delay: time.Second}, "echo hi", t.TempDir(), util.RunOptions{}, 10*time.Millisecond)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+
+	out, err = RunInDirWithTimeout(noContextRunner{}, "echo hi", t.TempDir(), util.RunOptions{}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", out)
+}
+
+// noContextRunner is a util.CommandRunner that isn't a util.ContextCommandRunner, taking delay to run each command.
+type noContextRunner struct {
+	delay time.Duration
+}
+
+func (r noContextRunner) Run(cmd string) (string, error) { return r.RunInDir(cmd, "") }
+
+func (r noContextRunner) RunInDir(cmd, dir string) (string, error) {
+	time.Sleep(r.delay)
+	return "ok", nil
+}
+
+func TestRetryPolicy_TimeoutEnv(t *testing.T) {
+	t.Setenv(prereqTimeoutEnvKey, "90s")
+
+	timeout, err := DefaultRetryPolicy.timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, timeout)
+}
@@ -0,0 +1,67 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/util"
+)
+
+// isFlatModule returns true if the module is laid out at the top level of a bundle (see project.ModuleFile.BundlePath),
+// which is the only layout that systemspec's bundle.Write can write.
+func isFlatModule(mod project.ModuleFile) bool {
+	return mod.BundlePath() == mod.Name+".wasm"
+}
+
+// addBundleModules adds each module to the .wasm.zip bundle at bundlePath at its BundlePath, such as namespace/name.wasm.
+// The bundle's existing entries are copied as they are.
+func addBundleModules(bundlePath string, modules []project.ModuleFile) error {
+	if len(modules) == 0 {
+		return nil
+	}
+
+	r, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to OpenReader bundle")
+	}
+
+	defer r.Close()
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+
+	for _, f := range r.File {
+		if err := w.Copy(f); err != nil {
+			return errors.Wrapf(err, "failed to Copy %s", f.Name)
+		}
+	}
+
+	for _, mod := range modules {
+		f, err := w.Create(mod.BundlePath())
+		if err != nil {
+			return errors.Wrapf(err, "failed to Create %s", mod.BundlePath())
+		}
+
+		if _, err := io.Copy(f, mod.File); err != nil {
+			return errors.Wrapf(err, "failed to write %s", mod.File.Name())
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "failed to Close bundle writer")
+	}
+
+	// the bundle is closed before it is replaced, which windows requires.
+	r.Close()
+
+	if err := ioutil.WriteFile(bundlePath, buf.Bytes(), util.PermFile); err != nil {
+		return errors.Wrap(err, "failed to WriteFile bundle")
+	}
+
+	return nil
+}
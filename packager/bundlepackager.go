@@ -2,6 +2,7 @@ package packager
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/pkg/errors"
@@ -15,10 +16,31 @@ import (
 
 const bundlePackageJobType = "bundle"
 
-type BundlePackageJob struct{}
+// BundleFormat is the format a BundlePackageJob writes the bundle in.
+type BundleFormat string
 
+const (
+	// BundleFormatZip writes the bundle as a .wasm.zip file at the context's Bundle.Fullpath.
+	BundleFormatZip = BundleFormat("zip")
+	// BundleFormatOCI writes the bundle as an OCI artifact in an OCI image layout directory alongside
+	// where the .wasm.zip bundle would be (see OCILayoutPath), ready to be pushed to a registry.
+	BundleFormatOCI = BundleFormat("oci")
+)
+
+type BundlePackageJob struct {
+	format BundleFormat
+}
+
+// NewBundlePackageJob returns a job that writes the project's bundle as a .wasm.zip file.
 func NewBundlePackageJob() PackageJob {
-	b := &BundlePackageJob{}
+	return NewBundlePackageJobWithFormat(BundleFormatZip)
+}
+
+// NewBundlePackageJobWithFormat returns a job that writes the project's bundle in the given format.
+func NewBundlePackageJobWithFormat(format BundleFormat) PackageJob {
+	b := &BundlePackageJob{
+		format: format,
+	}
 
 	return b
 }
@@ -55,15 +77,26 @@ func (b *BundlePackageJob) Package(log util.FriendlyLogger, ctx *project.Context
 		ctx.TenantConfig.TenantVersion++
 	}
 
-	if err := project.WriteTenantConfig(ctx.Cwd, ctx.TenantConfig); err != nil {
-		return errors.Wrap(err, "failed to WriteTenantConfig")
+	tenantConfigPath := ctx.TenantConfigPath
+	if tenantConfigPath == "" {
+		tenantConfigPath = filepath.Join(ctx.Cwd, project.DefaultTenantConfigFilename)
+	}
+
+	if err := project.WriteTenantConfigFile(tenantConfigPath, ctx.TenantConfig); err != nil {
+		return errors.Wrap(err, "failed to WriteTenantConfigFile")
+	}
+
+	// transforms (such as environment-specific overrides) only apply to the bundled copy, never to tenant.json.
+	bundleConfig, err := ctx.TransformedTenantConfig()
+	if err != nil {
+		return errors.Wrap(err, "🚫 failed to TransformedTenantConfig")
 	}
 
-	if err := project.CalculateModuleRefs(ctx.TenantConfig, ctx.Modules); err != nil {
+	if err := project.CalculateModuleRefs(bundleConfig, ctx.Modules); err != nil {
 		return errors.Wrap(err, "🚫 failed to CalculateModuleRefs")
 	}
 
-	if err := ctx.TenantConfig.Validate(); err != nil {
+	if err := bundleConfig.Validate(); err != nil {
 		return errors.Wrap(err, "🚫 failed to Validate Directive")
 	}
 
@@ -76,30 +109,57 @@ func (b *BundlePackageJob) Package(log util.FriendlyLogger, ctx *project.Context
 		log.LogInfo("adding static files to bundle")
 	}
 
-	configBytes, err := ctx.TenantConfig.Marshal()
+	configBytes, err := bundleConfig.Marshal()
 	if err != nil {
 		return errors.Wrap(err, "failed to Directive.Marshal")
 	}
 
-	moduleFiles, err := ctx.ModuleFiles()
+	moduleFiles, err := ctx.NamespacedModuleFiles()
 	if err != nil {
 		return errors.Wrap(err, "failed to Modules for build")
 	}
 
+	// modules outside the default namespace are laid out as namespace/name.wasm, which bundle.Write can't do,
+	// so they are added to the bundle once it has been written.
+	modules := []os.File{}
+	namespacedModules := []project.ModuleFile{}
+
 	for i := range moduleFiles {
-		defer moduleFiles[i].Close()
+		defer moduleFiles[i].File.Close()
+
+		if isFlatModule(moduleFiles[i]) {
+			modules = append(modules, *moduleFiles[i].File)
+		} else {
+			namespacedModules = append(namespacedModules, moduleFiles[i])
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ctx.Bundle.Fullpath), util.PermDirectory); err != nil {
+		return errors.Wrap(err, "failed to MkdirAll bundle directory")
+	}
+
+	if b.format == BundleFormatOCI {
+		layoutPath := OCILayoutPath(ctx)
+		tag := fmt.Sprintf("v%d", ctx.TenantConfig.TenantVersion)
+
+		if err := WriteOCILayout(layoutPath, tag, configBytes, moduleFiles, static); err != nil {
+			return errors.Wrap(err, "🚫 failed to WriteOCILayout")
+		}
+
+		log.LogDone(fmt.Sprintf("OCI artifact was created -> %s:%s", layoutPath, tag))
+
+		return nil
 	}
 
-	if err := bundle.Write(configBytes, moduleFiles, static, ctx.Bundle.Fullpath); err != nil {
+	if err := bundle.Write(configBytes, modules, static, ctx.Bundle.Fullpath); err != nil {
 		return errors.Wrap(err, "🚫 failed to WriteBundle")
 	}
 
-	bundleRef := project.BundleRef{
-		Exists:   true,
-		Fullpath: filepath.Join(ctx.Cwd, "modules.wasm.zip"),
+	if err := addBundleModules(ctx.Bundle.Fullpath, namespacedModules); err != nil {
+		return errors.Wrap(err, "🚫 failed to addBundleModules")
 	}
 
-	ctx.Bundle = bundleRef
+	ctx.Bundle.Exists = true
 
 	log.LogDone(fmt.Sprintf("bundle was created -> %s @ v%d", ctx.Bundle.Fullpath, ctx.TenantConfig.TenantVersion))
 
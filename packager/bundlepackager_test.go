@@ -0,0 +1,102 @@
+package packager
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/util"
+	"github.com/suborbital/systemspec/bundle"
+	"github.com/suborbital/systemspec/tenant"
+)
+
+// writeProject writes a project with a built module named hello in each of the given namespaces (the default
+// namespace if there are none) and a tenant.json to a new directory.
+func writeProject(t *testing.T, namespaces ...string) string {
+	root := t.TempDir()
+
+	if len(namespaces) == 0 {
+		namespaces = []string{"default"}
+	}
+
+	for _, namespace := range namespaces {
+		modDir := filepath.Join(root, namespace, "hello")
+		manifest := "name: hello\nlang: rust\nnamespace: " + namespace + "\n"
+
+		require.NoError(t, os.MkdirAll(modDir, util.PermDirectory))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(modDir, ".module.yaml"), []byte(manifest), util.PermFile))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(modDir, "hello.wasm"), []byte("\x00asm "+namespace), util.PermFile))
+	}
+
+	ctx, err := project.ForDirectory(root)
+	require.NoError(t, err)
+	require.NoError(t, project.WriteTenantConfig(root, ctx.GenerateTenantConfig("com.suborbital.app", 1)))
+
+	return root
+}
+
+func TestBundlePackageJob_TransformsOnlyApplyToBundle(t *testing.T) {
+	plain, transformed := writeProject(t), writeProject(t)
+
+	for _, root := range []string{plain, transformed} {
+		ctx, err := project.ForDirectory(root)
+		require.NoError(t, err)
+
+		if root == transformed {
+			ctx.AddTenantConfigTransform(func(cfg *tenant.Config) error {
+				cfg.Identifier += ".staging"
+				return nil
+			})
+		}
+
+		require.NoError(t, NewBundlePackageJob().Package(&util.PrintLogger{}, ctx))
+	}
+
+	plainConfig, err := ioutil.ReadFile(filepath.Join(plain, project.DefaultTenantConfigFilename))
+	require.NoError(t, err)
+
+	transformedConfig, err := ioutil.ReadFile(filepath.Join(transformed, project.DefaultTenantConfigFilename))
+	require.NoError(t, err)
+
+	assert.Equal(t, string(plainConfig), string(transformedConfig), "transforms are not written back to tenant.json")
+
+	b, err := bundle.Read(filepath.Join(transformed, "modules.wasm.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, "com.suborbital.app.staging", b.TenantConfig.Identifier)
+}
+
+func TestBundlePackageJob_NamespacedLayout(t *testing.T) {
+	root := writeProject(t, "default", "auth")
+
+	ctx, err := project.ForDirectory(root)
+	require.NoError(t, err)
+	require.NoError(t, NewBundlePackageJob().Package(&util.PrintLogger{}, ctx))
+
+	r, err := zip.OpenReader(filepath.Join(root, "modules.wasm.zip"))
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	contents := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+
+		b, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+
+		contents[f.Name] = string(b)
+	}
+
+	// modules with the same name in different namespaces don't collide, and the default namespace stays flat.
+	assert.Equal(t, "\x00asm default", contents["hello.wasm"])
+	assert.Equal(t, "\x00asm auth", contents["auth/hello.wasm"])
+	assert.Contains(t, contents, project.DefaultTenantConfigFilename)
+}
@@ -0,0 +1,198 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/project"
+	"github.com/suborbital/subo/subo/util"
+)
+
+// DefaultOCILayoutDirname is the name of the OCI image layout directory written by BundleFormatOCI.
+const DefaultOCILayoutDirname = "modules.oci"
+
+const (
+	ociImageManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociImageIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociEmptyMediaType         = "application/vnd.oci.empty.v1+json"
+
+	// bundleArtifactType identifies a subo bundle among the artifacts in a registry.
+	bundleArtifactType      = "application/vnd.suborbital.bundle.v1"
+	tenantConfigMediaType   = "application/vnd.suborbital.tenant.config.v1+json"
+	wasmModuleMediaType     = "application/vnd.wasm.content.layer.v1+wasm"
+	staticFileMediaType     = "application/octet-stream"
+	ociTitleAnnotation      = "org.opencontainers.image.title"
+	ociRefNameAnnotation    = "org.opencontainers.image.ref.name"
+	ociLayoutVersionContent = `{"imageLayoutVersion":"1.0.0"}`
+)
+
+// ociDescriptor describes a blob in an OCI image layout.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is an OCI image manifest describing an artifact.
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	ArtifactType  string          `json:"artifactType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociIndex is the index.json of an OCI image layout.
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// OCILayoutPath returns the path of the OCI image layout directory written for the context's bundle,
+// which is alongside where the .wasm.zip bundle would be written.
+func OCILayoutPath(ctx *project.Context) string {
+	return filepath.Join(filepath.Dir(ctx.Bundle.Fullpath), DefaultOCILayoutDirname)
+}
+
+// WriteOCILayout writes the tenant config, modules and static files of a bundle to layoutPath as an OCI artifact
+// in an OCI image layout, tagged with tag. Each file is a layer titled with its name within a .wasm.zip bundle,
+// so that pulling the artifact (such as with `oras pull`) recreates the bundle's contents. Any existing layout
+// at layoutPath is replaced. The artifact can be pushed with `oras cp --from-oci-layout <layoutPath>:<tag> <ref>`.
+func WriteOCILayout(layoutPath, tag string, tenantConfigBytes []byte, modules []project.ModuleFile, staticFiles map[string]os.File) error {
+	if len(tenantConfigBytes) == 0 {
+		return errors.New("tenant config must be provided")
+	}
+
+	if err := os.RemoveAll(layoutPath); err != nil {
+		return errors.Wrap(err, "failed to RemoveAll existing layout")
+	}
+
+	if err := os.MkdirAll(filepath.Join(layoutPath, "blobs", "sha256"), util.PermDirectory); err != nil {
+		return errors.Wrap(err, "failed to MkdirAll blobs directory")
+	}
+
+	layers := []ociDescriptor{}
+
+	configLayer, err := writeOCIBlob(layoutPath, tenantConfigMediaType, tenantConfigBytes)
+	if err != nil {
+		return errors.Wrap(err, "failed to write tenant config")
+	}
+
+	configLayer.Annotations = map[string]string{ociTitleAnnotation: project.DefaultTenantConfigFilename}
+	layers = append(layers, configLayer)
+
+	for _, mod := range modules {
+		contents, err := ioutil.ReadAll(mod.File)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read file %s", mod.File.Name())
+		}
+
+		layer, err := writeOCIBlob(layoutPath, wasmModuleMediaType, contents)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write %s", mod.File.Name())
+		}
+
+		layer.Annotations = map[string]string{ociTitleAnnotation: mod.BundlePath()}
+		layers = append(layers, layer)
+	}
+
+	// static files are added in a consistent order, so that the same files always produce the same manifest.
+	staticPaths := make([]string, 0, len(staticFiles))
+	for path := range staticFiles {
+		staticPaths = append(staticPaths, path)
+	}
+
+	sort.Strings(staticPaths)
+
+	for _, path := range staticPaths {
+		file := staticFiles[path]
+
+		contents, err := ioutil.ReadAll(&file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read file %s", file.Name())
+		}
+
+		layer, err := writeOCIBlob(layoutPath, staticFileMediaType, contents)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write %s", path)
+		}
+
+		title := path
+		if !strings.HasPrefix(title, "static/") {
+			title = "static/" + title
+		}
+
+		layer.Annotations = map[string]string{ociTitleAnnotation: title}
+		layers = append(layers, layer)
+	}
+
+	emptyConfig, err := writeOCIBlob(layoutPath, ociEmptyMediaType, []byte("{}"))
+	if err != nil {
+		return errors.Wrap(err, "failed to write config")
+	}
+
+	manifestBytes, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociImageManifestMediaType,
+		ArtifactType:  bundleArtifactType,
+		Config:        emptyConfig,
+		Layers:        layers,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to Marshal manifest")
+	}
+
+	manifest, err := writeOCIBlob(layoutPath, ociImageManifestMediaType, manifestBytes)
+	if err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+
+	manifest.Annotations = map[string]string{ociRefNameAnnotation: tag}
+
+	indexBytes, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociImageIndexMediaType,
+		Manifests:     []ociDescriptor{manifest},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to Marshal index")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(layoutPath, "index.json"), indexBytes, util.PermFile); err != nil {
+		return errors.Wrap(err, "failed to WriteFile index.json")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(layoutPath, "oci-layout"), []byte(ociLayoutVersionContent), util.PermFile); err != nil {
+		return errors.Wrap(err, "failed to WriteFile oci-layout")
+	}
+
+	return nil
+}
+
+// writeOCIBlob writes contents to the layout's blob store, named by its digest, and returns its descriptor.
+func writeOCIBlob(layoutPath, mediaType string, contents []byte) (ociDescriptor, error) {
+	sum := sha256.Sum256(contents)
+	encoded := hex.EncodeToString(sum[:])
+
+	if err := ioutil.WriteFile(filepath.Join(layoutPath, "blobs", "sha256", encoded), contents, util.PermFile); err != nil {
+		return ociDescriptor{}, errors.Wrap(err, "failed to WriteFile blob")
+	}
+
+	desc := ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + encoded,
+		Size:      int64(len(contents)),
+	}
+
+	return desc, nil
+}
@@ -0,0 +1,108 @@
+package project
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+// ForArchive extracts the .tar.gz archive at archivePath into a temporary directory and returns
+// the build context for it. The returned cleanup func removes the temporary directory, and must be
+// called once the context is no longer needed.
+func ForArchive(archivePath string) (*Context, func() error, error) {
+	tmpDir, err := os.MkdirTemp("", "subo-archive-")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to MkdirTemp")
+	}
+
+	cleanup := func() error {
+		return os.RemoveAll(tmpDir)
+	}
+
+	if err := extractArchive(archivePath, tmpDir); err != nil {
+		cleanup()
+		return nil, nil, errors.Wrap(err, "failed to extractArchive")
+	}
+
+	bctx, err := ForDirectory(tmpDir)
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.Wrap(err, "failed to ForDirectory")
+	}
+
+	return bctx, cleanup, nil
+}
+
+// extractArchive extracts the directories and regular files of the .tar.gz archive at archivePath into dest.
+// Other entries (such as symlinks) are skipped, and entries that would be written outside of dest are rejected.
+func extractArchive(archivePath, dest string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to Open")
+	}
+
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.Wrap(err, "failed to gzip.NewReader")
+	}
+
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(header.Name))
+		if target != dest && !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+			return fmt.Errorf("archive entry %s is outside of the extraction directory", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, util.PermDirectory); err != nil {
+				return errors.Wrapf(err, "failed to MkdirAll %s", header.Name)
+			}
+		case tar.TypeReg:
+			if err := extractArchiveFile(tr, target); err != nil {
+				return errors.Wrapf(err, "failed to extract %s", header.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func extractArchiveFile(r io.Reader, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), util.PermDirectory); err != nil {
+		return errors.Wrap(err, "failed to MkdirAll")
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, util.PermFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to OpenFile")
+	}
+
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		return errors.Wrap(err, "failed to Copy")
+	}
+
+	return nil
+}
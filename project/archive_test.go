@@ -0,0 +1,60 @@
+package project
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), "project.tar.gz")
+
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	for name, contents := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	return archivePath
+}
+
+func TestForArchive(t *testing.T) {
+	archivePath := writeTestArchive(t, map[string]string{
+		"hello/.module.yaml": "name: hello\nnamespace: default\nlang: rust\n",
+	})
+
+	bctx, cleanup, err := ForArchive(archivePath)
+	require.NoError(t, err)
+	require.Len(t, bctx.Modules, 1)
+	assert.Equal(t, "hello", bctx.Modules[0].Name)
+
+	require.NoError(t, cleanup())
+	assert.NoDirExists(t, bctx.Cwd)
+}
+
+func TestForArchive_RejectsPathTraversal(t *testing.T) {
+	archivePath := writeTestArchive(t, map[string]string{
+		"../escape/.module.yaml": "name: escape\nlang: rust\n",
+	})
+
+	_, _, err := ForArchive(archivePath)
+	assert.ErrorContains(t, err, "outside of the extraction directory")
+}
@@ -0,0 +1,85 @@
+package project
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+// DefaultBuildManifestFilename is the filename of the build manifest written alongside a project's bundle.
+const DefaultBuildManifestFilename = "build-manifest.json"
+
+// BuildManifest is an inventory of the modules produced by a build.
+type BuildManifest struct {
+	Modules []BuildManifestModule `json:"modules"`
+}
+
+// BuildManifestModule describes a single built module.
+type BuildManifestModule struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version,omitempty"`
+	// BuildImage is only set if the module overrides the default builder image for its language.
+	BuildImage  string            `json:"buildImage,omitempty"`
+	Size        int64             `json:"size"`
+	Checksum    string            `json:"checksum"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// BuildManifest returns the build manifest for the context's modules, which must all have been built.
+// Each module's size and checksum are read from its own .wasm file, since modules in different namespaces
+// can share a name.
+func (b *Context) BuildManifest() (*BuildManifest, error) {
+	manifest := &BuildManifest{Modules: make([]BuildManifestModule, 0, len(b.Modules))}
+
+	for _, mod := range b.Modules {
+		info, err := os.Stat(mod.WasmPath())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to Stat %s", mod.Name)
+		}
+
+		checksum, err := mod.ModuleChecksum()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to ModuleChecksum for %s", mod.Name)
+		}
+
+		manifest.Modules = append(manifest.Modules, BuildManifestModule{
+			Name:        mod.Name,
+			Namespace:   mod.Module.Namespace,
+			Lang:        mod.Module.Lang,
+			Version:     mod.Version,
+			BuildImage:  mod.BuildImage,
+			Size:        info.Size(),
+			Checksum:    checksum,
+			Description: mod.Description,
+			Labels:      mod.Labels,
+		})
+	}
+
+	return manifest, nil
+}
+
+// WriteManifest writes the context's build manifest to path as JSON.
+func (b *Context) WriteManifest(path string) error {
+	manifest, err := b.BuildManifest()
+	if err != nil {
+		return errors.Wrap(err, "failed to BuildManifest")
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to MarshalIndent")
+	}
+
+	if err := ioutil.WriteFile(path, manifestJSON, util.PermFile); err != nil {
+		return errors.Wrap(err, "failed to WriteFile")
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package project
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/suborbital/systemspec/fqmn"
+	"github.com/suborbital/systemspec/tenant"
+	"github.com/suborbital/systemspec/tenant/executable"
+)
+
+// BuildOrder returns the context's modules ordered so that each module comes after the modules called by the
+// earlier steps of any workflow that calls it, so that a module whose input is another's output is built later.
+// Modules that are not ordered relative to each other, including those not called by any workflow, keep their
+// discovery order. An error is returned if the workflows call modules in an order that forms a cycle.
+func (b *Context) BuildOrder() ([]ModuleDir, error) {
+	index := make(map[string]int, len(b.Modules))
+	for i := range b.Modules {
+		index[b.Modules[i].NamespacedName()] = i
+	}
+
+	// after[i] holds the modules that must be built after module i.
+	after := make([]map[int]bool, len(b.Modules))
+	inDegree := make([]int, len(b.Modules))
+
+	if b.TenantConfig != nil {
+		namespaces := append([]tenant.NamespaceConfig{b.TenantConfig.DefaultNamespace}, b.TenantConfig.Namespaces...)
+
+		for _, ns := range namespaces {
+			for _, wf := range ns.Workflows {
+				var previous []int
+
+				for _, step := range wf.Steps {
+					current := stepModuleIndexes(step, ns.Name, index)
+
+					for _, from := range previous {
+						for _, to := range current {
+							if from == to || after[from][to] {
+								continue
+							}
+
+							if after[from] == nil {
+								after[from] = map[int]bool{}
+							}
+
+							after[from][to] = true
+							inDegree[to]++
+						}
+					}
+
+					if len(current) > 0 {
+						previous = current
+					}
+				}
+			}
+		}
+	}
+
+	ordered := make([]ModuleDir, 0, len(b.Modules))
+	built := make([]bool, len(b.Modules))
+
+	for len(ordered) < len(b.Modules) {
+		next := -1
+		for i := range b.Modules {
+			if !built[i] && inDegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			cycle := []string{}
+			for i, mod := range b.Modules {
+				if !built[i] {
+					cycle = append(cycle, mod.Name)
+				}
+			}
+
+			sort.Strings(cycle)
+
+			return nil, fmt.Errorf("workflows call modules %s in an order that forms a cycle", strings.Join(cycle, ", "))
+		}
+
+		built[next] = true
+		ordered = append(ordered, b.Modules[next])
+
+		for to := range after[next] {
+			inDegree[to]--
+		}
+	}
+
+	return ordered, nil
+}
+
+// stepModuleIndexes returns the index of each module called by a workflow step that exists in index, which is keyed
+// by NamespacedName. A step that doesn't name a namespace calls a module in its workflow's namespace.
+func stepModuleIndexes(step executable.Executable, namespace string, index map[string]int) []int {
+	if namespace == "" {
+		namespace = fqmn.NamespaceDefault
+	}
+
+	mods := []executable.ExecutableMod{}
+	if step.IsFn() {
+		mods = append(mods, step.ExecutableMod)
+	} else if step.IsGroup() {
+		mods = append(mods, step.Group...)
+	}
+
+	indexes := []int{}
+
+	for _, mod := range mods {
+		FQMN, err := fqmn.Parse(mod.FQMN)
+		if err != nil {
+			continue
+		}
+
+		modNamespace := FQMN.Namespace
+		if modNamespace == "" {
+			modNamespace = namespace
+		}
+
+		if i, exists := index[modNamespace+"::"+FQMN.Name]; exists {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes
+}
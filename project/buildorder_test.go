@@ -0,0 +1,115 @@
+package project
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/systemspec/tenant"
+	"github.com/suborbital/systemspec/tenant/executable"
+)
+
+func TestContext_BuildOrder(t *testing.T) {
+	step := func(names ...string) executable.Executable {
+		if len(names) == 1 {
+			return executable.Executable{ExecutableMod: executable.ExecutableMod{FQMN: "fqmn://tenant/default/" + names[0]}}
+		}
+
+		group := []executable.ExecutableMod{}
+		for _, name := range names {
+			group = append(group, executable.ExecutableMod{FQMN: "fqmn://tenant/default/" + name})
+		}
+
+		return executable.Executable{Group: group}
+	}
+
+	modules := []ModuleDir{{Name: "report"}, {Name: "unused"}, {Name: "fetch"}, {Name: "parse"}, {Name: "auth"}}
+
+	names := func(mods []ModuleDir) []string {
+		out := []string{}
+		for _, mod := range mods {
+			out = append(out, mod.Name)
+		}
+
+		return out
+	}
+
+	tests := []struct {
+		name      string
+		workflows []tenant.Workflow
+		want      []string
+		wantErr   string
+	}{
+		{
+			name: "no tenant config",
+			want: []string{"report", "unused", "fetch", "parse", "auth"},
+		},
+		{
+			name: "chain",
+			workflows: []tenant.Workflow{
+				{Name: "pipeline", Steps: []executable.Executable{step("auth"), step("fetch", "parse"), step("report")}},
+			},
+			want: []string{"unused", "auth", "fetch", "parse", "report"},
+		},
+		{
+			name: "cycle",
+			workflows: []tenant.Workflow{
+				{Name: "forward", Steps: []executable.Executable{step("fetch"), step("parse")}},
+				{Name: "backward", Steps: []executable.Executable{step("parse"), step("fetch")}},
+			},
+			wantErr: "fetch, parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &Context{Modules: modules}
+			if tt.workflows != nil {
+				ctx.TenantConfig = &tenant.Config{DefaultNamespace: tenant.NamespaceConfig{Workflows: tt.workflows}}
+			}
+
+			got, err := ctx.BuildOrder()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, names(got))
+		})
+	}
+}
+
+func TestContext_BuildOrder_Namespaces(t *testing.T) {
+	step := func(fqmn string) executable.Executable {
+		return executable.Executable{ExecutableMod: executable.ExecutableMod{FQMN: fqmn}}
+	}
+
+	inNamespace := func(name, namespace string) ModuleDir {
+		return ModuleDir{Name: name, Module: &tenant.Module{Name: name, Namespace: namespace}}
+	}
+
+	// a and b both have a module named x, only b's workflow orders its x after y.
+	ctx := &Context{
+		Modules: []ModuleDir{inNamespace("x", "b"), inNamespace("y", "b"), inNamespace("x", "a")},
+		TenantConfig: &tenant.Config{
+			Namespaces: []tenant.NamespaceConfig{{
+				Name: "b",
+				Workflows: []tenant.Workflow{
+					{Name: "pipeline", Steps: []executable.Executable{step("fqmn://tenant/b/y"), step("fqmn://tenant//x")}},
+				},
+			}},
+		},
+	}
+
+	got, err := ctx.BuildOrder()
+	require.NoError(t, err)
+
+	order := []string{}
+	for i := range got {
+		order = append(order, got[i].NamespacedName())
+	}
+
+	assert.Equal(t, []string{"b::y", "b::x", "a::x"}, order)
+}
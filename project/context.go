@@ -1,18 +1,28 @@
 package project
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/hashicorp/go-version"
+	"github.com/pelletier/go-toml"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 
 	"github.com/suborbital/subo/subo/release"
-	"github.com/suborbital/subo/subo/util"
+	"github.com/suborbital/systemspec/fqmn"
 	"github.com/suborbital/systemspec/tenant"
 )
 
@@ -22,287 +32,2116 @@ var validLangs = map[string]struct{}{
 	"swift":          {},
 	"assemblyscript": {},
 	"tinygo":         {},
+	"go":             {},
+	"zig":            {},
+	"c":              {},
 	"grain":          {},
 	"typescript":     {},
 	"javascript":     {},
 	"wat":            {},
 }
 
+// langAliases maps other names that module manifests may use for a language, such as file extensions,
+// to the language's canonical name in validLangs. Note that typescript is a language of its own, built
+// with the javascript toolchain, so ts is not an alias of assemblyscript.
+var langAliases = map[string]string{
+	"rs":     "rust",
+	"as":     "assemblyscript",
+	"ts":     "typescript",
+	"js":     "javascript",
+	"golang": "go",
+	"gr":     "grain",
+}
+
+// DefaultModuleSearchDepth is the maximum number of directory levels below the project root that are searched for
+// modules, unless Options.SearchDepth is set.
+const DefaultModuleSearchDepth = 5
+
+// DefaultModuleManifestPattern is the filepath.Match pattern that the names of module manifest files match, in
+// addition to a bare .module file, unless Options.ManifestPattern is set. It only matches dot-prefixed manifests
+// such as .module.yaml.
+const DefaultModuleManifestPattern = ".module.*"
+
+// ignoredDirs are directories that are never searched for modules.
+var ignoredDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	"target":       true,
+}
+
 // Context describes the context under which the tool is being run.
 type Context struct {
-	Cwd            string
-	CwdIsModule    bool
-	Modules        []ModuleDir
-	Bundle         BundleRef
-	TenantConfig   *tenant.Config
-	RuntimeVersion string
-	Langs          []string
-	MountPath      string
-	RelDockerPath  string
-	BuilderTag     string
+	Cwd              string         `json:"cwd"`
+	CwdIsModule      bool           `json:"cwdIsModule"`
+	Modules          []ModuleDir    `json:"modules"`
+	Bundle           BundleRef      `json:"bundle"`
+	TenantConfig     *tenant.Config `json:"tenantConfig,omitempty"`
+	TenantConfigPath string         `json:"tenantConfigPath"`
+	RuntimeVersion   string         `json:"runtimeVersion,omitempty"`
+	Langs            []string       `json:"langs"`
+	ExcludeLangs     []string       `json:"excludeLangs,omitempty"`
+	MountPath        string         `json:"mountPath"`
+	RelDockerPath    string         `json:"relDockerPath"`
+	BuilderTag       string         `json:"builderTag"`
+	// BuilderTagsForLang overrides BuilderTag for the builder images of specific languages.
+	BuilderTagsForLang map[string]string `json:"builderTagsForLang,omitempty"`
+	BuilderRegistry    string            `json:"builderRegistry,omitempty"`
+	BuildFilter        string            `json:"buildFilter,omitempty"`
+	SkipPrereqs        bool              `json:"skipPrereqs,omitempty"`
+	// BuilderPlatform is the platform (such as linux/arm64) to run every builder image as, overriding the default.
+	BuilderPlatform string `json:"builderPlatform,omitempty"`
+	// BuilderDigestsForLang pins the builder images of specific languages to a digest (such as sha256:...),
+	// in which case the digest is used instead of the tag.
+	BuilderDigestsForLang map[string]string `json:"builderDigestsForLang,omitempty"`
+	// TenantConfigRaw is the unparsed contents of the tenant config file, for tools that edit it
+	// textually to preserve its formatting and ordering. It is nil if there is no tenant config.
+	TenantConfigRaw []byte `json:"-"`
+	// BuildEnv is forwarded into every builder container. It may contain secrets, so it is never serialized.
+	BuildEnv map[string]string `json:"-"`
+	// Roots are the directories that a context created by ForDirectories discovered its modules in, it is empty otherwise.
+	Roots []string `json:"roots,omitempty"`
+
+	// discovery holds the options that the context's modules were discovered with, so that Refresh finds them the same way.
+	discovery Options
+
+	tenantConfigTransforms []TenantConfigTransform
 }
 
 // ModuleDir represents a directory containing a module.
 type ModuleDir struct {
-	Name           string
-	UnderscoreName string
-	Fullpath       string
-	Module         *tenant.Module
-	CompilerFlags  string
+	Name           string         `json:"name"`
+	UnderscoreName string         `json:"underscoreName"`
+	Fullpath       string         `json:"fullpath"`
+	Module         *tenant.Module `json:"module"`
+	CompilerFlags  string         `json:"compilerFlags,omitempty"`
+	BuildImage     string         `json:"buildImage,omitempty"`
+	Version        string         `json:"version,omitempty"`
+	SourceDir      string         `json:"sourceDir"`
+	Prereqs        []ModulePrereq `json:"prereqs,omitempty"`
+	// BuildFlags are appended to the module's build command, such as `--features` for cargo.
+	BuildFlags []string `json:"buildFlags,omitempty"`
+	// Artifacts are the files the module's build produces besides its .wasm file, such as a .wat dump.
+	// Each is a path or glob pattern relative to Fullpath.
+	Artifacts []string `json:"artifacts,omitempty"`
+	// Description and Labels (such as owner or team) are informational metadata for tooling, and do not affect the build.
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// BuildImageDigest pins BuildImage to a digest (such as sha256:...), in which case the digest is used instead of its tag.
+	BuildImageDigest string `json:"buildImageDigest,omitempty"`
+	// BuildEnv is forwarded into the builder container that builds the module. It may contain secrets, so it is never serialized.
+	BuildEnv map[string]string `json:"-"`
+}
+
+// ModulePrereq is an additional pre-requisite file declared by a module, paired with
+// the command that creates it. These run after the default pre-requisites for the module's language.
+type ModulePrereq struct {
+	File    string `yaml:"file" json:"file"`
+	Command string `yaml:"command" json:"command"`
+}
+
+// moduleExtensions are the subo-specific fields of a .module.yaml file that are not part of tenant.Module.
+type moduleExtensions struct {
+	BuildImage       string            `yaml:"buildImage,omitempty" json:"buildImage,omitempty"`
+	BuildImageDigest string            `yaml:"buildImageDigest,omitempty" json:"buildImageDigest,omitempty"`
+	SourceDir        string            `yaml:"sourceDir,omitempty" json:"sourceDir,omitempty"`
+	Prereqs          []ModulePrereq    `yaml:"prereqs,omitempty" json:"prereqs,omitempty"`
+	BuildEnv         map[string]string `yaml:"buildEnv,omitempty" json:"buildEnv,omitempty"`
+	Version          string            `yaml:"version,omitempty" json:"version,omitempty"`
+	BuildFlags       []string          `yaml:"buildFlags,omitempty" json:"buildFlags,omitempty"`
+	Artifacts        []string          `yaml:"artifacts,omitempty" json:"artifacts,omitempty"`
+	Description      string            `yaml:"description,omitempty" json:"description,omitempty"`
+	Labels           map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
 }
 
 // BundleRef contains information about a bundle in the current context.
 type BundleRef struct {
-	Exists   bool
-	Fullpath string
+	Exists   bool   `json:"exists"`
+	Fullpath string `json:"fullpath"`
+	// ModTime is the bundle's modification time, and is zero if it does not exist.
+	ModTime time.Time `json:"modTime"`
+}
+
+const (
+	// builderTagEnvKey is the environment variable that overrides the default builder image tag.
+	builderTagEnvKey = "SUBO_BUILDER_TAG"
+
+	// builderTagForLangEnvPrefix prefixes environment variables that override the builder image tag
+	// for a single language, such as SUBO_BUILDER_TAG_rust (or SUBO_BUILDER_TAG_RUST).
+	builderTagForLangEnvPrefix = builderTagEnvKey + "_"
+
+	// builderDigestForLangEnvPrefix prefixes environment variables that pin the builder image
+	// for a single language to a digest, such as SUBO_BUILDER_DIGEST_rust=sha256:....
+	builderDigestForLangEnvPrefix = "SUBO_BUILDER_DIGEST_"
+
+	// builderRegistryEnvKey is the environment variable that sets a registry to pull builder images from.
+	builderRegistryEnvKey = "SUBO_BUILDER_REGISTRY"
+
+	// builderPlatformEnvKey is the environment variable that sets the platform to run builder images as.
+	builderPlatformEnvKey = "SUBO_BUILDER_PLATFORM"
+
+	// buildEnvPrefix prefixes environment variables that are forwarded into builder containers with the prefix removed,
+	// for example SUBO_BUILD_ENV_NPM_TOKEN is forwarded as NPM_TOKEN.
+	buildEnvPrefix = "SUBO_BUILD_ENV_"
+
+	// dotEnvFilename is the file in the project root, or in a module's directory, whose variables are forwarded into builder containers.
+	dotEnvFilename = ".env"
+)
+
+// DefaultBundleName is the filename used for a project's bundle unless otherwise specified.
+const DefaultBundleName = "modules.wasm.zip"
+
+// Options configure how a Context is created for a directory.
+type Options struct {
+	// BundleName is the filename of the project's bundle, DefaultBundleName if empty.
+	BundleName string
+	// Langs restricts module discovery to the listed languages, all languages are discovered if empty.
+	Langs []string
+	// BundleDir is the directory the bundle is written to, relative to the working directory unless absolute.
+	// The working directory is used if empty.
+	BundleDir string
+	// TenantConfigPath is the path of the tenant config, relative to the working directory unless absolute.
+	// If empty, the optional tenant.json in the working directory is used. Otherwise the file must exist.
+	TenantConfigPath string
+	// WarnOnUnknownLang logs a warning and skips modules with an unsupported lang, rather than failing.
+	WarnOnUnknownLang bool
+	// NamespaceFromParentDir defaults the namespace of modules that don't set one to the lowercased name of
+	// the directory containing the module's directory, rather than "default". Modules at the root of the
+	// project still default to "default".
+	NamespaceFromParentDir bool
+	// SearchDepth is the maximum number of directory levels below the project root that are searched for modules,
+	// DefaultModuleSearchDepth if 0.
+	SearchDepth int
+	// ManifestPattern is the filepath.Match pattern that module manifest filenames match in addition to a bare
+	// .module file, DefaultModuleManifestPattern if empty. It can be "*.module.*" for projects that name
+	// manifests after their module, such as service.module.yaml.
+	ManifestPattern string
+}
+
+// searchDepth returns o.SearchDepth, or DefaultModuleSearchDepth if it is not set.
+func (o Options) searchDepth() int {
+	if o.SearchDepth > 0 {
+		return o.SearchDepth
+	}
+
+	return DefaultModuleSearchDepth
+}
+
+// manifestPattern returns o.ManifestPattern, or DefaultModuleManifestPattern if it is not set.
+func (o Options) manifestPattern() string {
+	if o.ManifestPattern != "" {
+		return o.ManifestPattern
+	}
+
+	return DefaultModuleManifestPattern
 }
 
 // ForDirectory returns the build context for the provided working directory.
 func ForDirectory(dir string) (*Context, error) {
+	return ForDirectoryWithOptions(dir, Options{})
+}
+
+// ForDirectoryWithBundleName returns the build context for the provided working directory,
+// using bundleName as the filename of the project's bundle.
+func ForDirectoryWithBundleName(dir, bundleName string) (*Context, error) {
+	return ForDirectoryWithOptions(dir, Options{BundleName: bundleName})
+}
+
+// ForDirectoryWithOptions returns the build context for the provided working directory, configured by opts.
+func ForDirectoryWithOptions(dir string, opts Options) (*Context, error) {
+	bctx, errs, err := forDirectory(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	return bctx, nil
+}
+
+// ForDirectoryPartial returns a best-effort build context for the provided working directory, configured by opts.
+// Problems with the tenant config, Queries.yaml or Connections.yaml do not cause it to fail, instead they are
+// returned as non-fatal errors alongside a context that has no config (or lacks the broken parts of it).
+// Errors reading the filesystem or discovering modules are still fatal.
+func ForDirectoryPartial(dir string, opts Options) (*Context, []error, error) {
+	return forDirectory(dir, opts)
+}
+
+func forDirectory(dir string, opts Options) (*Context, []error, error) {
 	fullDir, err := filepath.Abs(dir)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get Abs path")
+		return nil, nil, errors.Wrap(err, "failed to get Abs path")
+	}
+
+	bundleName := opts.BundleName
+	if bundleName == "" {
+		bundleName = DefaultBundleName
 	}
 
-	modules, cwdIsModule, err := getModuleDirs(fullDir)
+	langs := opts.Langs
+	if langs == nil {
+		langs = []string{}
+	}
+
+	opts.Langs = langs
+
+	modules, cwdIsModule, err := getModuleDirs(fullDir, opts)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to getModuleDirs")
+		return nil, nil, errors.Wrap(err, "failed to getModuleDirs")
 	}
 
-	bundle, err := bundleTargetPath(fullDir)
+	bundle, err := bundleTargetPath(resolvePath(fullDir, opts.BundleDir), bundleName)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to bundleIfExists")
+		return nil, nil, errors.Wrap(err, "failed to bundleIfExists")
+	}
+
+	tenantConfigPath := filepath.Join(fullDir, DefaultTenantConfigFilename)
+	if opts.TenantConfigPath != "" {
+		tenantConfigPath = resolvePath(fullDir, opts.TenantConfigPath)
 	}
 
-	config, err := readTenantConfig(fullDir)
+	var errs []error
+
+	config, configRaw, err := readTenantConfig(tenantConfigPath)
 	if err != nil {
-		if !os.IsNotExist(errors.Cause(err)) {
-			return nil, errors.Wrap(err, "failed to readDirectiveFile")
+		// the default tenant config is optional, but one that was asked for by path must exist.
+		if !os.IsNotExist(errors.Cause(err)) || opts.TenantConfigPath != "" {
+			errs = append(errs, errors.Wrap(err, "failed to readTenantConfig"))
 		}
+
+		config = nil
 	}
 
 	queries, err := readQueriesFile(dir)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to readQueriesFile")
-	} else if len(queries) > 0 {
+		errs = append(errs, errors.Wrap(err, "failed to readQueriesFile"))
+	} else if len(queries) > 0 && config != nil {
 		config.DefaultNamespace.Queries = queries
 	}
 
 	connections, err := readConnectionsFile(dir)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to readConnectionsFile")
-	} else if len(connections) > 0 {
+		errs = append(errs, errors.Wrap(err, "failed to readConnectionsFile"))
+	} else if len(connections) > 0 && config != nil {
 		config.DefaultNamespace.Connections = connections
 	}
 
+	dotEnv, err := readDotEnv(os.DirFS(fullDir), ".")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to readDotEnv")
+	}
+
+	builderTag := fmt.Sprintf("v%s", release.SuboVersion)
+	if envTag, exists := os.LookupEnv(builderTagEnvKey); exists && envTag != "" {
+		builderTag = envTag
+	}
+
 	bctx := &Context{
-		Cwd:           fullDir,
-		CwdIsModule:   cwdIsModule,
-		Modules:       modules,
-		Bundle:        *bundle,
-		TenantConfig:  config,
-		Langs:         []string{},
-		MountPath:     fullDir,
-		RelDockerPath: ".",
-		BuilderTag:    fmt.Sprintf("v%s", release.SuboVersion),
+		Cwd:                fullDir,
+		CwdIsModule:        cwdIsModule,
+		Modules:            modules,
+		Bundle:             *bundle,
+		TenantConfig:       config,
+		TenantConfigPath:   tenantConfigPath,
+		TenantConfigRaw:    configRaw,
+		Langs:              langs,
+		MountPath:          fullDir,
+		RelDockerPath:      ".",
+		BuilderTag:         builderTag,
+		BuilderTagsForLang: langValuesFromEnviron(os.Environ(), builderTagForLangEnvPrefix),
+		BuilderRegistry:    os.Getenv(builderRegistryEnvKey),
+		BuilderPlatform:    os.Getenv(builderPlatformEnvKey),
+		BuildEnv:           mergeDotEnv(buildEnvFromEnviron(os.Environ()), dotEnv),
 	}
 
-	return bctx, nil
+	bctx.discovery = opts
+
+	bctx.BuilderDigestsForLang = langValuesFromEnviron(os.Environ(), builderDigestForLangEnvPrefix)
+	for lang, digest := range bctx.BuilderDigestsForLang {
+		if err := ValidateImageDigest(digest); err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid %s%s", builderDigestForLangEnvPrefix, lang)
+		}
+	}
+
+	return bctx, errs, nil
 }
 
-// ModuleExists returns true if the context contains a module with name <name>.
-func (b *Context) ModuleExists(name string) bool {
-	for _, r := range b.Modules {
-		if r.Name == name {
-			return true
+// langValuesFromEnviron returns the per-language values set in environ (formatted as KEY=value)
+// by variables named prefix followed by a language, keyed by lowercase language.
+func langValuesFromEnviron(environ []string, prefix string) map[string]string {
+	values := map[string]string{}
+
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || value == "" || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if lang := strings.ToLower(strings.TrimPrefix(key, prefix)); lang != "" {
+			values[lang] = value
 		}
 	}
 
-	return false
+	return values
 }
 
-// ShouldBuildLang returns true if the provided language is safe-listed for building.
-func (b *Context) ShouldBuildLang(lang string) bool {
-	if len(b.Langs) == 0 {
-		return true
+// BuilderTagForLang returns the builder image tag for the given language,
+// which is BuilderTag unless it is overridden for the language by BuilderTagsForLang.
+func (b *Context) BuilderTagForLang(lang string) string {
+	if tag, exists := b.BuilderTagsForLang[lang]; exists && tag != "" {
+		return tag
 	}
 
-	for _, l := range b.Langs {
-		if l == lang {
-			return true
+	return b.BuilderTag
+}
+
+// BuilderDigestForLang returns the digest the builder image for the given language is pinned to, if any.
+func (b *Context) BuilderDigestForLang(lang string) string {
+	return b.BuilderDigestsForLang[lang]
+}
+
+// ValidateImageDigest returns an error if digest is not an image content digest, such as sha256:<64 hex characters>.
+func ValidateImageDigest(digest string) error {
+	algorithm, hash, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(hash) != 64 {
+		return fmt.Errorf("%q is not a sha256 image digest, such as sha256:<64 hex characters>", digest)
+	}
+
+	for _, r := range hash {
+		if !((r >= '0' && r <= '9') || (r >= 'a' && r <= 'f')) {
+			return fmt.Errorf("%q is not a sha256 image digest, such as sha256:<64 hex characters>", digest)
 		}
 	}
 
-	return false
+	return nil
 }
 
-func (b *Context) ModuleFiles() ([]os.File, error) {
-	modules := []os.File{}
-
-	for _, r := range b.Modules {
-		wasmPath := filepath.Join(r.Fullpath, fmt.Sprintf("%s.wasm", r.Name))
+// buildEnvFromEnviron returns the variables in environ (formatted as KEY=value) that start with buildEnvPrefix,
+// keyed by their name with the prefix removed.
+func buildEnvFromEnviron(environ []string) map[string]string {
+	env := map[string]string{}
 
-		file, err := os.Open(wasmPath)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to Open module file %s", wasmPath)
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, buildEnvPrefix) || key == buildEnvPrefix {
+			continue
 		}
 
-		modules = append(modules, *file)
+		env[strings.TrimPrefix(key, buildEnvPrefix)] = value
 	}
 
-	return modules, nil
+	return env
 }
 
-// HasDockerfile returns a nil error if the project's Dockerfile exists.
-func (b *Context) HasDockerfile() error {
-	dockerfilePath := filepath.Join(b.Cwd, "Dockerfile")
+// parseDotEnv parses the contents of a .env file, which has one KEY=value per line. Blank lines, lines starting
+// with #, and an `export ` before the key are ignored, and a value wrapped in single or double quotes is unquoted.
+func parseDotEnv(data []byte) (map[string]string, error) {
+	env := map[string]string{}
 
-	if _, err := os.Stat(dockerfilePath); err != nil {
-		return errors.Wrap(err, "failed to Stat Dockerfile")
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d is not formatted as KEY=value", i+1)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		env[key] = value
 	}
 
-	return nil
+	return env, nil
 }
 
-// WasmFile returns a file object for the .wasm file. It is the caller's responsibility to close the file.
-func (m *ModuleDir) WasmFile() (io.ReadCloser, error) {
-	modulePath := filepath.Join(m.Fullpath, fmt.Sprintf("%s.wasm", m.Name))
+// readDotEnv returns the variables in the .env file in dir within fsys, or nil if there isn't one.
+func readDotEnv(fsys fs.FS, dir string) (map[string]string, error) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, dotEnvFilename))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
 
-	wasmFile, err := os.Open(modulePath)
+		return nil, errors.Wrapf(err, "failed to ReadFile %s", dotEnvFilename)
+	}
+
+	env, err := parseDotEnv(data)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to Open %s", modulePath)
+		return nil, errors.Wrapf(err, "failed to parse %s", path.Join(dir, dotEnvFilename))
 	}
 
-	return wasmFile, nil
+	return env, nil
 }
 
-// HasWasmFile returns a nil error if the module's .wasm file exists.
-func (m *ModuleDir) HasWasmFile() error {
-	modulePath := filepath.Join(m.Fullpath, fmt.Sprintf("%s.wasm", m.Name))
+// mergeDotEnv adds the variables from a .env file to env unless env already sets them, and returns env.
+// The OS environment wins over the .env file, so a variable that is also set in the OS environment, either with
+// buildEnvPrefix or as is, takes its value from there.
+func mergeDotEnv(env, dotEnv map[string]string) map[string]string {
+	if len(dotEnv) == 0 {
+		return env
+	}
 
-	if _, err := os.Stat(modulePath); err != nil {
-		return errors.Wrapf(err, "failed to Stat %s", modulePath)
+	if env == nil {
+		env = map[string]string{}
 	}
 
-	return nil
+	for key, value := range dotEnv {
+		if _, exists := env[key]; exists {
+			continue
+		}
+
+		if osValue, exists := os.LookupEnv(buildEnvPrefix + key); exists {
+			value = osValue
+		} else if osValue, exists := os.LookupEnv(key); exists {
+			value = osValue
+		}
+
+		env[key] = value
+	}
+
+	return env
 }
 
-func getModuleDirs(cwd string) ([]ModuleDir, bool, error) {
-	modules := []ModuleDir{}
+// SetBundleDir moves the context's bundle into dir, keeping its filename. A relative dir is resolved
+// against the context's working directory, and an empty dir resets it to the working directory.
+func (b *Context) SetBundleDir(dir string) error {
+	bundleName := filepath.Base(b.Bundle.Fullpath)
+	if b.Bundle.Fullpath == "" {
+		bundleName = DefaultBundleName
+	}
 
-	// Go through all of the dirs in the current dir.
-	topLvlFiles, err := ioutil.ReadDir(cwd)
+	bundle, err := bundleTargetPath(resolvePath(b.Cwd, dir), bundleName)
 	if err != nil {
-		return nil, false, errors.Wrap(err, "failed to list directory")
+		return errors.Wrap(err, "failed to bundleTargetPath")
 	}
 
-	// Check to see if we're running from within a Module directory
-	// and return true if so.
-	moduleDir, err := getModuleFromFiles(cwd, topLvlFiles)
+	b.Bundle = *bundle
+
+	return nil
+}
+
+// resolvePath returns p resolved against cwd, or cwd if p is empty.
+func resolvePath(cwd, p string) string {
+	if p == "" {
+		return cwd
+	}
+
+	if filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(cwd, p)
+}
+
+// ToJSON returns the context's metadata encoded as JSON, for use by external tools.
+func (b *Context) ToJSON() ([]byte, error) {
+	contextJSON, err := json.Marshal(b)
 	if err != nil {
-		return nil, false, errors.Wrap(err, "failed to getModuleFromFiles")
-	} else if moduleDir != nil {
-		modules = append(modules, *moduleDir)
-		return modules, true, nil
+		return nil, errors.Wrap(err, "failed to Marshal")
 	}
 
-	for _, tf := range topLvlFiles {
-		if !tf.IsDir() {
-			continue
+	return contextJSON, nil
+}
+
+// Summary returns a human-readable description of the context: the number of modules found and their
+// languages, whether a tenant config and bundle exist, and the runtime version.
+func (b *Context) Summary() string {
+	counts := map[string]int{}
+	for _, m := range b.Modules {
+		counts[m.Module.Lang]++
+	}
+
+	modules := "no modules"
+	if len(b.Modules) > 0 {
+		langs := []string{}
+		for _, lang := range b.UsedLangs() {
+			langs = append(langs, fmt.Sprintf("%d %s", counts[lang], lang))
 		}
 
-		dirPath := filepath.Join(cwd, tf.Name())
+		modules = fmt.Sprintf("%d module(s) (%s)", len(b.Modules), strings.Join(langs, ", "))
+	}
+
+	runtimeVersion := b.RuntimeVersion
+	if runtimeVersion == "" {
+		runtimeVersion = "any"
+	}
 
-		// Determine if a .module file exists in that dir.
-		innerFiles, err := ioutil.ReadDir(dirPath)
-		if err != nil {
-			util.LogWarn(fmt.Sprintf("couldn't read files in %v", dirPath))
-			continue
+	return fmt.Sprintf("%s, tenant config: %s, bundle: %s, runtime version: %s", modules, yesNo(b.TenantConfig != nil), yesNo(b.Bundle.Exists), runtimeVersion)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// ModuleExists returns true if the context contains a module with name <name>.
+func (b *Context) ModuleExists(name string) bool {
+	for _, r := range b.Modules {
+		if r.Name == name {
+			return true
 		}
+	}
 
-		moduleDir, err := getModuleFromFiles(dirPath, innerFiles)
-		if err != nil {
-			return nil, false, errors.Wrap(err, "failed to getModuleFromFiles")
-		} else if moduleDir == nil {
+	return false
+}
+
+// DuplicateModuleNames returns the sorted NamespacedName of every module whose name collides with another
+// module's in the same namespace. Names that differ only by punctuation, such as '-' and '_', are considered
+// colliding, since they produce the same IdentifierName.
+func (b *Context) DuplicateModuleNames() []string {
+	colliding := map[string][]string{}
+	for i := range b.Modules {
+		// the module's namespace followed by its IdentifierName, such as default::hello_world.
+		key := strings.TrimSuffix(b.Modules[i].NamespacedName(), b.Modules[i].Name) + b.Modules[i].IdentifierName()
+		colliding[key] = append(colliding[key], b.Modules[i].NamespacedName())
+	}
+
+	seen := map[string]bool{}
+	dupes := []string{}
+
+	for _, names := range colliding {
+		if len(names) < 2 {
 			continue
 		}
 
-		modules = append(modules, *moduleDir)
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				dupes = append(dupes, name)
+			}
+		}
 	}
 
-	return modules, false, nil
+	sort.Strings(dupes)
+
+	return dupes
 }
 
-// ContainsModuleYaml finds any .module file in a list of files.
-func ContainsModuleYaml(files []os.FileInfo) (string, bool) {
-	for _, f := range files {
-		if strings.HasPrefix(f.Name(), ".module.") {
-			return f.Name(), true
+// SetBuildLangs restricts building to modules of the listed languages, which may use any case or alias
+// (see CanonicalLang). An empty list builds every language.
+func (b *Context) SetBuildLangs(langs []string) {
+	b.Langs = canonicalLangs(langs)
+}
+
+// SetExcludeLangs prevents modules for the listed languages from being built. Like SetBuildLangs,
+// languages may use any case or alias.
+func (b *Context) SetExcludeLangs(langs []string) {
+	b.ExcludeLangs = canonicalLangs(langs)
+}
+
+// canonicalLangs returns the CanonicalLang of each of langs.
+func canonicalLangs(langs []string) []string {
+	if langs == nil {
+		return nil
+	}
+
+	canonical := make([]string, len(langs))
+	for i, lang := range langs {
+		canonical[i] = CanonicalLang(lang)
+	}
+
+	return canonical
+}
+
+// ShouldBuildLang returns true if the provided language is safe-listed for building.
+// A language in the exclude list is never built, even if it is also safe-listed.
+func (b *Context) ShouldBuildLang(lang string) bool {
+	for _, l := range b.ExcludeLangs {
+		if l == lang {
+			return false
+		}
+	}
+
+	if len(b.Langs) == 0 {
+		return true
+	}
+
+	for _, l := range b.Langs {
+		if l == lang {
+			return true
 		}
 	}
 
-	return "", false
+	return false
 }
 
-// IsValidLang returns true if a language is valid.
-func IsValidLang(lang string) bool {
-	_, exists := validLangs[lang]
+// SetBuildFilter restricts building to modules whose name matches the glob pattern,
+// using filepath.Match semantics. An empty pattern builds every module.
+// A malformed pattern is rejected rather than silently matching nothing.
+func (b *Context) SetBuildFilter(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return errors.Wrapf(err, "invalid build filter %q", pattern)
+	}
 
-	return exists
+	b.BuildFilter = pattern
+
+	return nil
 }
 
-func getModuleFromFiles(wd string, files []os.FileInfo) (*ModuleDir, error) {
-	filename, exists := ContainsModuleYaml(files)
-	if !exists {
-		return nil, nil
+// ShouldBuildModule returns true if the module name matches the context's build filter.
+func (b *Context) ShouldBuildModule(name string) bool {
+	if b.BuildFilter == "" {
+		return true
 	}
 
-	moduleBytes, err := ioutil.ReadFile(filepath.Join(wd, filename))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to ReadFile .module yaml")
+	matched, err := filepath.Match(b.BuildFilter, name)
+
+	return err == nil && matched
+}
+
+// ShouldBuild returns true if the module passes both the language and name filters.
+func (b *Context) ShouldBuild(mod ModuleDir) bool {
+	return b.ShouldBuildLang(mod.Module.Lang) && b.ShouldBuildModule(mod.Name)
+}
+
+// ForEachModule calls fn for each module that passes the context's build filters (see ShouldBuild),
+// stopping at and returning the first error that fn returns.
+func (b *Context) ForEachModule(fn func(ModuleDir) error) error {
+	for _, mod := range b.Modules {
+		if !b.ShouldBuild(mod) {
+			continue
+		}
+
+		if err := fn(mod); err != nil {
+			return err
+		}
 	}
 
-	module := &tenant.Module{}
-	if err := yaml.Unmarshal(moduleBytes, &module); err != nil {
-		return nil, errors.Wrap(err, "failed to Unmarshal .module yaml")
+	return nil
+}
+
+// UsedLangs returns the sorted, de-duplicated languages of the modules in the context.
+// Unlike Langs, which filters what gets built, this reflects what is actually present.
+func (b *Context) UsedLangs() []string {
+	langMap := map[string]bool{}
+	for _, m := range b.Modules {
+		langMap[m.Module.Lang] = true
 	}
 
-	if module.Name == "" {
-		module.Name = filepath.Base(wd)
+	langs := []string{}
+	for lang := range langMap {
+		langs = append(langs, lang)
 	}
 
-	if module.Namespace == "" {
-		module.Namespace = "default"
+	sort.Strings(langs)
+
+	return langs
+}
+
+// ModulesByNamespace returns the modules in the context grouped by their namespace, each group in the same
+// order as b.Modules. Modules without a namespace are grouped under "default".
+func (b *Context) ModulesByNamespace() map[string][]ModuleDir {
+	namespaces := map[string][]ModuleDir{}
+	for _, m := range b.Modules {
+		namespace := m.Module.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+
+		namespaces[namespace] = append(namespaces[namespace], m)
+	}
+
+	return namespaces
+}
+
+// moduleFileConcurrency is the maximum number of module files opened at once by ModuleFiles.
+const moduleFileConcurrency = 8
+
+// ModuleFiles opens the .wasm file for each module concurrently, returning them in the same order as b.Modules.
+// If any file fails to open, all of the others are closed before the error is returned.
+// It is the caller's responsibility to close the files.
+func (b *Context) ModuleFiles() ([]*os.File, error) {
+	return b.ModuleFilesContext(context.Background())
+}
+
+// ModuleFilesContext is ModuleFiles, but stops opening files once ctx is done. If ctx is cancelled before
+// every file is opened, the files that were opened are closed and ctx's error is returned.
+func (b *Context) ModuleFilesContext(ctx context.Context) ([]*os.File, error) {
+	files := make([]*os.File, len(b.Modules))
+	errs := make([]error, len(b.Modules))
+
+	sem := make(chan struct{}, moduleFileConcurrency)
+	wg := sync.WaitGroup{}
+
+	for i := range b.Modules {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			wasmPath := b.Modules[i].WasmPath()
+
+			file, err := os.Open(wasmPath)
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "failed to Open module file %s", wasmPath)
+				return
+			}
+
+			files[i] = file
+		}(i)
 	}
 
-	if ok := IsValidLang(module.Lang); !ok {
-		return nil, fmt.Errorf("(%s) %s is not a valid lang", module.Name, module.Lang)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		closeFiles(files)
+		return nil, err
 	}
 
-	absolutePath, err := filepath.Abs(wd)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get Abs filepath")
+	for _, err := range errs {
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// ModuleFile is an open module .wasm file along with the module's identity.
+type ModuleFile struct {
+	Namespace string
+	Name      string
+	File      *os.File
+}
+
+// BundlePath returns the path at which the module should be laid out in a bundle.
+// Modules in the default namespace use a flat layout (name.wasm), others use namespace/name.wasm.
+func (m ModuleFile) BundlePath() string {
+	filename := fmt.Sprintf("%s.wasm", m.Name)
+
+	if m.Namespace == "" || m.Namespace == "default" {
+		return filename
+	}
+
+	return path.Join(m.Namespace, filename)
+}
+
+// NamespacedModuleFiles opens the .wasm file for each module like ModuleFiles, pairing each with
+// the module's namespace and name. It is the caller's responsibility to close the files.
+func (b *Context) NamespacedModuleFiles() ([]ModuleFile, error) {
+	files, err := b.ModuleFiles()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ModuleFiles")
+	}
+
+	modFiles := make([]ModuleFile, len(files))
+	for i := range files {
+		modFiles[i] = ModuleFile{
+			Namespace: b.Modules[i].Module.Namespace,
+			Name:      b.Modules[i].Name,
+			File:      files[i],
+		}
+	}
+
+	return modFiles, nil
+}
+
+// closeFiles closes every non-nil file in files.
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// HasDockerfile returns a nil error if the project's Dockerfile exists.
+func (b *Context) HasDockerfile() error {
+	dockerfilePath := filepath.Join(b.Cwd, "Dockerfile")
+
+	if _, err := os.Stat(dockerfilePath); err != nil {
+		return errors.Wrap(err, "failed to Stat Dockerfile")
+	}
+
+	return nil
+}
+
+// ModuleSizes returns the size in bytes of each module's built .wasm file keyed by its NamespacedName, along with their total.
+// An error is returned if any module has not been built.
+func (b *Context) ModuleSizes() (map[string]int64, int64, error) {
+	sizes := make(map[string]int64, len(b.Modules))
+	total := int64(0)
+
+	for i := range b.Modules {
+		info, err := os.Stat(b.Modules[i].WasmPath())
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to Stat %s", b.Modules[i].Name)
+		}
+
+		sizes[b.Modules[i].NamespacedName()] = info.Size()
+		total += info.Size()
+	}
+
+	return sizes, total, nil
+}
+
+// BundleIsStale returns true if the bundle does not exist or is older than any of the modules' built .wasm files.
+// Every module must have been built.
+func (b *Context) BundleIsStale() (bool, error) {
+	if !b.Bundle.Exists {
+		return true, nil
+	}
+
+	for i := range b.Modules {
+		info, err := os.Stat(b.Modules[i].WasmPath())
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to Stat %s", b.Modules[i].Name)
+		}
+
+		if info.ModTime().After(b.Bundle.ModTime) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ModuleChecksums returns the SHA256 checksum of each module's built .wasm file keyed by its NamespacedName.
+func (b *Context) ModuleChecksums() (map[string]string, error) {
+	checksums := make(map[string]string, len(b.Modules))
+
+	for i := range b.Modules {
+		checksum, err := b.Modules[i].ModuleChecksum()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to ModuleChecksum for %s", b.Modules[i].Name)
+		}
+
+		checksums[b.Modules[i].NamespacedName()] = checksum
+	}
+
+	return checksums, nil
+}
+
+// ModuleArtifacts returns the paths of every module's build artifacts, keyed by its NamespacedName.
+// See ModuleDir.ArtifactPaths.
+func (b *Context) ModuleArtifacts() (map[string][]string, error) {
+	artifacts := make(map[string][]string, len(b.Modules))
+
+	for i := range b.Modules {
+		paths, err := b.Modules[i].ArtifactPaths()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to ArtifactPaths for %s", b.Modules[i].Name)
+		}
+
+		artifacts[b.Modules[i].NamespacedName()] = paths
+	}
+
+	return artifacts, nil
+}
+
+// OrphanedModules returns the paths of .wasm files in module directories that do not belong to any module,
+// such as those left behind when a module is renamed. Each module's own .wasm file and its declared
+// artifacts are not orphaned.
+func (b *Context) OrphanedModules() ([]string, error) {
+	expected := map[string]bool{}
+	for i := range b.Modules {
+		expected[b.Modules[i].WasmPath()] = true
+
+		for _, artifact := range b.Modules[i].Artifacts {
+			matches, _ := filepath.Glob(filepath.Join(b.Modules[i].Fullpath, artifact))
+			for _, match := range matches {
+				expected[match] = true
+			}
+		}
+	}
+
+	orphans := []string{}
+	searched := map[string]bool{}
+
+	for i := range b.Modules {
+		dir := b.Modules[i].Fullpath
+		if searched[dir] {
+			continue
+		}
+
+		searched[dir] = true
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to ReadDir %s", dir)
+		}
+
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			if file.IsDir() || filepath.Ext(file.Name()) != ".wasm" || expected[path] {
+				continue
+			}
+
+			orphans = append(orphans, path)
+		}
+	}
+
+	sort.Strings(orphans)
+
+	return orphans, nil
+}
+
+// Clean removes the built .wasm file and declared artifacts of each module, any orphaned .wasm files
+// (see OrphanedModules), and the project's bundle. Files that do not exist are skipped, and every failed removal is reported in the returned error.
+func (b *Context) Clean() error {
+	paths := make([]string, 0, len(b.Modules)+1)
+	for i := range b.Modules {
+		paths = append(paths, b.Modules[i].WasmPath())
+
+		for _, artifact := range b.Modules[i].Artifacts {
+			// the pattern was validated when the module was discovered, so the error can be ignored.
+			matches, _ := filepath.Glob(filepath.Join(b.Modules[i].Fullpath, artifact))
+			paths = append(paths, matches...)
+		}
+	}
+
+	orphans, err := b.OrphanedModules()
+	if err != nil {
+		return errors.Wrap(err, "failed to OrphanedModules")
+	}
+
+	paths = append(paths, orphans...)
+
+	failed := []string{}
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	// the bundle only stops existing once it has been removed.
+	if b.Bundle.Fullpath != "" {
+		if err := os.Remove(b.Bundle.Fullpath); err != nil && !os.IsNotExist(err) {
+			failed = append(failed, err.Error())
+		} else {
+			b.Bundle.Exists = false
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove %d build artifact(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// CheckRuntimeVersion returns an error if the context's RuntimeVersion is outside
+// of the range supported by this version of subo. An empty RuntimeVersion matches any version.
+func (b *Context) CheckRuntimeVersion() error {
+	if b.RuntimeVersion == "" {
+		return nil
+	}
+
+	runtimeVersion, err := version.NewVersion(b.RuntimeVersion)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse runtime version %s", b.RuntimeVersion)
+	}
+
+	supported, err := version.NewConstraint(release.SupportedRuntimeVersions)
+	if err != nil {
+		return errors.Wrap(err, "failed to NewConstraint")
+	}
+
+	if !supported.Check(runtimeVersion) {
+		return fmt.Errorf("runtime version %s is not supported by subo v%s (supported: %s), upgrade subo or use a supported runtime version", b.RuntimeVersion, release.SuboVersion, release.SupportedRuntimeVersions)
+	}
+
+	return nil
+}
+
+// CheckBuilderCompatibility returns an error if the builder image of any language used by the context's modules
+// is tagged with a version of subo that release.RuntimeCompatibilityTable doesn't list as compatible with
+// RuntimeVersion. Tags that are not versions (such as latest), custom build images, and an empty RuntimeVersion
+// can't be checked and are allowed.
+func (b *Context) CheckBuilderCompatibility() error {
+	if b.RuntimeVersion == "" {
+		return nil
+	}
+
+	compatible, err := release.CompatibleSuboVersions(b.RuntimeVersion)
+	if err != nil {
+		return errors.Wrap(err, "failed to CompatibleSuboVersions")
+	}
+
+	constraint, err := version.NewConstraint(compatible)
+	if err != nil {
+		return errors.Wrapf(err, "failed to NewConstraint %s", compatible)
+	}
+
+	langs := map[string]bool{}
+	for _, mod := range b.Modules {
+		if mod.BuildImage == "" {
+			langs[mod.Module.Lang] = true
+		}
+	}
+
+	incompatible := []string{}
+
+	for lang := range langs {
+		tag := b.BuilderTagForLang(lang)
+
+		tagVersion, err := version.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+
+		if !constraint.Check(tagVersion) {
+			incompatible = append(incompatible, fmt.Sprintf("%s (%s)", lang, tag))
+		}
+	}
+
+	if len(incompatible) > 0 {
+		sort.Strings(incompatible)
+
+		return fmt.Errorf("runtime version %s needs builder images from subo %s, but the builder images for %s are not", b.RuntimeVersion, compatible, strings.Join(incompatible, ", "))
+	}
+
+	return nil
+}
+
+// WasmPath returns the path of the module's built .wasm file.
+func (m *ModuleDir) WasmPath() string {
+	return filepath.Join(m.Fullpath, fmt.Sprintf("%s.wasm", m.Name))
+}
+
+// ArtifactPaths returns the path of the module's .wasm file followed by the paths of the other artifacts
+// its build produced, as declared by Artifacts. An error is returned if an artifact does not exist.
+func (m *ModuleDir) ArtifactPaths() ([]string, error) {
+	paths := []string{m.WasmPath()}
+
+	for _, artifact := range m.Artifacts {
+		matches, err := filepath.Glob(filepath.Join(m.Fullpath, artifact))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to Glob %s", artifact)
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("(%s) artifact %s was not produced by the build", m.Name, artifact)
+		}
+
+		paths = append(paths, matches...)
+	}
+
+	return paths, nil
+}
+
+// WasmFile returns a file object for the .wasm file. It is the caller's responsibility to close the file.
+func (m *ModuleDir) WasmFile() (io.ReadCloser, error) {
+	modulePath := m.WasmPath()
+
+	wasmFile, err := os.Open(modulePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to Open %s", modulePath)
+	}
+
+	return wasmFile, nil
+}
+
+// HasWasmFile returns a nil error if the module's .wasm file exists.
+func (m *ModuleDir) HasWasmFile() error {
+	modulePath := m.WasmPath()
+
+	if _, err := os.Stat(modulePath); err != nil {
+		return errors.Wrapf(err, "failed to Stat %s", modulePath)
+	}
+
+	return nil
+}
+
+// ModuleChecksum returns the hex-encoded SHA256 of the module's built .wasm file.
+func (m *ModuleDir) ModuleChecksum() (string, error) {
+	return fileChecksum(m.WasmPath())
+}
+
+// NamespacedName returns the module's namespace and name joined by "::", such as "default::hello", which identifies
+// it among a context's modules since modules in different namespaces can share a name.
+func (m *ModuleDir) NamespacedName() string {
+	namespace := fqmn.NamespaceDefault
+	if m.Module != nil && m.Module.Namespace != "" {
+		namespace = m.Module.Namespace
+	}
+
+	return namespace + "::" + m.Name
+}
+
+// IdentifierName returns the module's name as an identifier that is valid in each supported language:
+// every run of characters other than ASCII letters and digits is replaced by a single '_',
+// and it is prefixed with '_' if it would otherwise start with a digit. For example, "1-hello..world"
+// becomes "_1_hello_world". Unlike UnderscoreName, which only replaces '-' to match how cargo names build artifacts,
+// it is safe to use in generated source code.
+func (m *ModuleDir) IdentifierName() string {
+	return identifierName(m.Name)
+}
+
+func identifierName(name string) string {
+	ident := &strings.Builder{}
+	lastWasUnderscore := false
+
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			ident.WriteRune(r)
+			lastWasUnderscore = false
+
+			continue
+		}
+
+		if !lastWasUnderscore {
+			ident.WriteRune('_')
+			lastWasUnderscore = true
+		}
+	}
+
+	if id := ident.String(); id != "" && id[0] >= '0' && id[0] <= '9' {
+		return "_" + id
+	}
+
+	return ident.String()
+}
+
+// entryFilesForLang are the conventional source entry points for each language, relative to the source directory.
+// The swift entry point is templated with the module's name.
+var entryFilesForLang = map[string]string{
+	"rust":           "src/lib.rs",
+	"swift":          "Sources/%s/main.swift",
+	"assemblyscript": "src/index.ts",
+	"typescript":     "src/index.ts",
+	"javascript":     "src/index.js",
+	"tinygo":         "main.go",
+	"go":             "main.go",
+	"zig":            "src/main.zig",
+	"c":              "src/main.c",
+	"grain":          "index.gr",
+	"wat":            "lib.wat",
+}
+
+// EntryFile returns the path of the conventional source entry point for the module's language,
+// such as src/lib.rs for rust, within the module's source directory.
+func (m *ModuleDir) EntryFile() (string, error) {
+	entry, ok := entryFilesForLang[m.Module.Lang]
+	if !ok {
+		return "", fmt.Errorf("%s has no known entry file", m.Module.Lang)
+	}
+
+	if strings.Contains(entry, "%s") {
+		entry = fmt.Sprintf(entry, m.Name)
+	}
+
+	dir := m.SourceDir
+	if dir == "" {
+		dir = m.Fullpath
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(entry)), nil
+}
+
+// lockfilesForLang are the dependency lockfiles for each language, in order of preference.
+var lockfilesForLang = map[string][]string{
+	"rust":           {"Cargo.lock"},
+	"swift":          {"Package.resolved"},
+	"assemblyscript": {"package-lock.json", "yarn.lock", "pnpm-lock.yaml"},
+	"typescript":     {"package-lock.json", "yarn.lock", "pnpm-lock.yaml"},
+	"javascript":     {"package-lock.json", "yarn.lock", "pnpm-lock.yaml"},
+	"tinygo":         {"go.sum"},
+	"go":             {"go.sum"},
+	"zig":            {"build.zig.zon"},
+}
+
+// DependencyLockHash returns the hex-encoded SHA256 of the dependency lockfile in the module's source directory,
+// such as Cargo.lock or package-lock.json, for use as a cache key. An empty string is returned if there is none.
+func (m *ModuleDir) DependencyLockHash() (string, error) {
+	sourceDir := m.SourceDir
+	if sourceDir == "" {
+		sourceDir = m.Fullpath
+	}
+
+	for _, lockfile := range lockfilesForLang[m.Module.Lang] {
+		checksum, err := fileChecksum(filepath.Join(sourceDir, lockfile))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return "", errors.Wrapf(err, "failed to checksum %s", lockfile)
+		}
+
+		return checksum, nil
+	}
+
+	return "", nil
+}
+
+// generatedDirs are directories that builds and prerequisites generate within a module from its lockfile
+// or manifest, such as cargo's vendor directory, and so are not part of its source.
+var generatedDirs = map[string]bool{
+	".build": true,
+	"_lib":   true,
+	"vendor": true,
+}
+
+// SourceHash returns the hex-encoded SHA256 of the path and contents of every source file in the module's directory,
+// for use as a cache key along with DependencyLockHash. Built .wasm files, declared Artifacts, and directories
+// such as target, node_modules and vendor that are ignored or generated are not sources.
+func (m *ModuleDir) SourceHash() (string, error) {
+	artifacts := map[string]bool{}
+	for _, artifact := range m.Artifacts {
+		matches, _ := filepath.Glob(filepath.Join(m.Fullpath, artifact))
+		for _, match := range matches {
+			artifacts[match] = true
+		}
+	}
+
+	hash := sha256.New()
+
+	// Walk visits files in lexical order, so the hash doesn't depend on the order the OS lists them in.
+	err := filepath.Walk(m.Fullpath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != m.Fullpath && (ignoredDirs[info.Name()] || generatedDirs[info.Name()]) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if filepath.Ext(path) == ".wasm" || artifacts[path] {
+			return nil
+		}
+
+		rel, err := filepath.Rel(m.Fullpath, path)
+		if err != nil {
+			return errors.Wrap(err, "failed to get Rel path")
+		}
+
+		checksum, err := fileChecksum(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to checksum %s", rel)
+		}
+
+		fmt.Fprintf(hash, "%s %s\n", checksum, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to Walk module directory")
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileChecksum returns the hex-encoded SHA256 of the file at filePath.
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to Open")
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", errors.Wrap(err, "failed to Copy")
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// NeedsRebuild returns true if the module's .wasm file is missing or older than any of its source files.
+// The .wasm file itself and ignored directories such as target and node_modules are not considered sources.
+func (m *ModuleDir) NeedsRebuild() (bool, error) {
+	modulePath := m.WasmPath()
+
+	wasmInfo, err := os.Stat(modulePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+
+		return false, errors.Wrapf(err, "failed to Stat %s", modulePath)
+	}
+
+	needsRebuild := false
+
+	err = filepath.Walk(m.Fullpath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != m.Fullpath && ignoredDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if path != modulePath && info.ModTime().After(wasmInfo.ModTime()) {
+			needsRebuild = true
+			return io.EOF
+		}
+
+		return nil
+	})
+
+	if err != nil && err != io.EOF {
+		return false, errors.Wrap(err, "failed to Walk module directory")
+	}
+
+	return needsRebuild, nil
+}
+
+func getModuleDirs(cwd string, opts Options) ([]ModuleDir, bool, error) {
+	return DiscoverModules(os.DirFS(cwd), cwd, opts)
+}
+
+// DiscoverModules searches fsys for modules, returning them along with true if the root of fsys is itself a module.
+// root is the path that fsys represents, and is used to build each module's Fullpath. Only opts.Langs,
+// opts.WarnOnUnknownLang, opts.NamespaceFromParentDir, opts.SearchDepth and opts.ManifestPattern apply to discovery.
+// This allows modules to be discovered in virtual or embedded filesystems, such as fstest.MapFS.
+func DiscoverModules(fsys fs.FS, root string, opts Options) ([]ModuleDir, bool, error) {
+	modules := []ModuleDir{}
+
+	cwdIsModule, err := walkModules(fsys, root, opts, func(mod ModuleDir) error {
+		modules = append(modules, mod)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return modules, cwdIsModule, nil
+}
+
+// WalkModules discovers the modules in dir in the same way as ForDirectoryWithOptions, calling fn for each
+// module as soon as it is found rather than waiting for the whole directory tree to be searched. The search
+// stops at the first error returned by fn, which is returned as-is. If dir is itself a module, fn is called once.
+func WalkModules(dir string, opts Options, fn func(ModuleDir) error) error {
+	fullDir, err := filepath.Abs(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to get Abs path")
+	}
+
+	_, err = walkModules(os.DirFS(fullDir), fullDir, opts, fn)
+
+	return err
+}
+
+// walkModules calls fn for each module discovered in fsys, returning true if root is itself a module.
+func walkModules(fsys fs.FS, root string, opts Options, fn func(ModuleDir) error) (bool, error) {
+	// Go through all of the dirs in the current dir.
+	topLvlFiles, err := readDir(fsys, ".")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list directory")
+	}
+
+	finder, err := newModuleFinder(fsys, root, opts)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to newModuleFinder")
+	}
+
+	finder.visited[finder.realpath(".")] = true
+
+	// Check to see if we're running from within a Module directory
+	// and return true if so.
+	moduleDir, err := finder.getModuleFromFiles(".", topLvlFiles)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to getModuleFromFiles")
+	} else if moduleDir != nil {
+		// only the cwd's module is built, so make sure modules in its subdirectories aren't silently skipped.
+		if hidden, err := finder.find(".", topLvlFiles, 1); err == nil && len(hidden) > 0 {
+			logWarn(fmt.Sprintf("%s is a module, so the %d module(s) in its subdirectories will be ignored", root, len(hidden)))
+		}
+
+		return true, fn(*moduleDir)
+	}
+
+	if err := finder.walk(".", topLvlFiles, 1, fn); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// ForModule returns the module in dir, reading only its own manifest without searching any other directories.
+// An error is returned if dir does not contain a module manifest.
+func ForModule(dir string) (*ModuleDir, error) {
+	fullDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Abs path")
+	}
+
+	fsys := os.DirFS(fullDir)
+
+	files, err := readDir(fsys, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list directory")
+	}
+
+	finder := &moduleFinder{fsys: fsys, root: fullDir}
+
+	moduleDir, err := finder.getModuleFromFiles(".", files)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to getModuleFromFiles")
+	} else if moduleDir == nil {
+		return nil, fmt.Errorf("no module manifest (such as .module.yaml) found in %s", fullDir)
+	}
+
+	return moduleDir, nil
+}
+
+// manifestFormats are the formats ModuleFromReader can parse a module manifest from.
+var manifestFormats = map[string]struct{}{"yaml": {}, "yml": {}, "json": {}, "toml": {}}
+
+// ModuleFromReader returns the module whose manifest, in format (yaml, json or toml), is read from r, such as a
+// manifest generated in a pipeline and piped to subo. dir is the module's directory, which any relative paths in
+// the manifest (such as sourceDir) and its .env are resolved against, but no manifest is read from it.
+func ModuleFromReader(r io.Reader, dir, format string) (*ModuleDir, error) {
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	if _, ok := manifestFormats[format]; !ok {
+		return nil, fmt.Errorf("%s is not a supported manifest format, use yaml, json or toml", format)
+	}
+
+	fullDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Abs path")
+	}
+
+	moduleBytes, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadAll manifest")
+	}
+
+	finder := &moduleFinder{fsys: os.DirFS(fullDir), root: fullDir}
+
+	moduleDir, err := finder.moduleFromManifest(".", fullDir, ".module."+format, fmt.Sprintf("%s manifest for %s", format, fullDir), moduleBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to moduleFromManifest")
+	}
+
+	return moduleDir, nil
+}
+
+// readDir lists the directory name within fsys.
+func readDir(fsys fs.FS, name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]os.FileInfo, 0, len(entries))
+
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get Info for %s", e.Name())
+		}
+
+		files = append(files, info)
+	}
+
+	return files, nil
+}
+
+// moduleFinder searches the directories of a project for modules.
+// Directories are referred to by their slash-separated path within fsys, with "." being the project root.
+type moduleFinder struct {
+	fsys   fs.FS
+	root   string
+	ignore []string
+	langs  []string
+
+	// opts is used for its searchDepth and manifestPattern, so that a zero moduleFinder uses the defaults.
+	opts Options
+
+	// warnOnUnknownLang skips modules with an unsupported lang instead of returning an UnsupportedLangError.
+	warnOnUnknownLang bool
+
+	// namespaceFromParentDir defaults a module's namespace to the name of its parent directory.
+	namespaceFromParentDir bool
+
+	// visited holds the real path of each directory searched, to avoid following symlink cycles.
+	visited map[string]bool
+}
+
+// newModuleFinder returns a moduleFinder that searches fsys, which represents root, configured by opts
+// and the .suboignore file at the root of fsys.
+func newModuleFinder(fsys fs.FS, root string, opts Options) (*moduleFinder, error) {
+	ignore, err := readIgnoreFile(fsys)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to readIgnoreFile")
+	}
+
+	if _, err := filepath.Match(opts.manifestPattern(), ""); err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest pattern %q", opts.manifestPattern())
+	}
+
+	finder := &moduleFinder{
+		fsys:    fsys,
+		root:    root,
+		ignore:  ignore,
+		langs:   opts.Langs,
+		visited: map[string]bool{},
+		opts:    opts,
+
+		warnOnUnknownLang:      opts.WarnOnUnknownLang,
+		namespaceFromParentDir: opts.NamespaceFromParentDir,
+	}
+
+	return finder, nil
+}
+
+// searches returns true if walk would look for a module in dir, a path within fsys: it is no more than
+// the finder's search depth, and neither it nor any directory leading to it is skipped. It does not
+// check whether any directory leading to dir is itself a module, whose subdirectories are never searched.
+func (f *moduleFinder) searches(dir string) bool {
+	parts := strings.Split(path.Clean(dir), "/")
+	if len(parts) > f.opts.searchDepth() {
+		return false
+	}
+
+	for i := range parts {
+		if ignoredDirs[parts[i]] || f.isIgnored(path.Join(parts[:i+1]...)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// defaultNamespace returns the namespace of a module in dir that does not set one.
+func (f *moduleFinder) defaultNamespace(dir string) string {
+	if !f.namespaceFromParentDir {
+		return "default"
+	}
+
+	parent := path.Dir(path.Clean(dir))
+	if parent == "." || parent == "/" {
+		return "default"
+	}
+
+	return namespaceFromDirName(path.Base(parent))
+}
+
+// namespaceFromDirName returns a valid namespace (see validateNamespace) derived from a directory's name: it is
+// lowercased, each run of other invalid characters becomes a single '-', and any leading '-' or '_' (or trailing '-') is removed,
+// so that "My Team" becomes "my-team". It is "default" if nothing valid is left.
+func namespaceFromDirName(name string) string {
+	namespace := &strings.Builder{}
+	lastWasDash := false
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			namespace.WriteRune(r)
+			lastWasDash = false
+
+			continue
+		}
+
+		if !lastWasDash {
+			namespace.WriteRune('-')
+			lastWasDash = true
+		}
+	}
+
+	if trimmed := strings.TrimRight(strings.TrimLeft(namespace.String(), "-_"), "-"); trimmed != "" {
+		return trimmed
+	}
+
+	return "default"
+}
+
+// fullpath returns the path of dir within fsys joined to the project root.
+func (f *moduleFinder) fullpath(dir string) (string, error) {
+	return filepath.Abs(filepath.Join(f.root, filepath.FromSlash(dir)))
+}
+
+// realpath returns the full path of dir with any symlinks resolved. Directories that
+// don't exist on the OS filesystem (such as those in a virtual fs.FS) are returned unresolved.
+func (f *moduleFinder) realpath(dir string) string {
+	fullpath, err := f.fullpath(dir)
+	if err != nil {
+		return dir
+	}
+
+	if resolved, err := filepath.EvalSymlinks(fullpath); err == nil {
+		return resolved
+	}
+
+	return fullpath
+}
+
+// find recursively searches the subdirectories of dir for modules, descending no further
+// than the finder's search depth below the project root. A module's own subdirectories are not searched.
+func (f *moduleFinder) find(dir string, files []os.FileInfo, depth int) ([]ModuleDir, error) {
+	modules := []ModuleDir{}
+
+	err := f.walk(dir, files, depth, func(mod ModuleDir) error {
+		modules = append(modules, mod)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return modules, nil
+}
+
+// walk searches the subdirectories of dir in the same way as find, calling fn for each module as soon as it
+// is found. The search stops at the first error returned by fn, which is returned as-is.
+func (f *moduleFinder) walk(dir string, files []os.FileInfo, depth int, fn func(ModuleDir) error) error {
+	for _, tf := range files {
+		dirPath := path.Join(dir, tf.Name())
+
+		if ignoredDirs[tf.Name()] {
+			logDebug(fmt.Sprintf("skipping %s, it is never searched for modules", dirPath))
+			continue
+		}
+
+		isDir := tf.IsDir()
+		if tf.Mode()&fs.ModeSymlink != 0 {
+			// the listing describes the link itself, so Stat the target to see if it's a directory.
+			target, err := fs.Stat(f.fsys, dirPath)
+			if err != nil {
+				logWarn(fmt.Sprintf("couldn't follow symlink %v", dirPath))
+				continue
+			}
+
+			isDir = target.IsDir()
+		}
+
+		if !isDir {
+			continue
+		}
+
+		if f.isIgnored(dirPath) {
+			logDebug(fmt.Sprintf("skipping %s, it matches .suboignore", dirPath))
+			continue
+		}
+
+		realpath := f.realpath(dirPath)
+		if f.visited[realpath] {
+			logDebug(fmt.Sprintf("skipping %s, it has already been searched", dirPath))
+			continue
+		}
+
+		f.visited[realpath] = true
+
+		// Determine if a .module file exists in that dir.
+		innerFiles, err := readDir(f.fsys, dirPath)
+		if err != nil {
+			logWarn(fmt.Sprintf("couldn't read files in %v", dirPath))
+			continue
+		}
+
+		moduleDir, err := f.getModuleFromFiles(dirPath, innerFiles)
+		if err != nil {
+			return errors.Wrap(err, "failed to getModuleFromFiles")
+		} else if moduleDir != nil {
+			logDebug(fmt.Sprintf("found module %s in %s", moduleDir.Name, dirPath))
+
+			if err := fn(*moduleDir); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if depth >= f.opts.searchDepth() {
+			logDebug(fmt.Sprintf("not searching below %s, it is at the maximum search depth", dirPath))
+		} else if err := f.walk(dirPath, innerFiles, depth+1, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isIgnored returns true if dirPath matches any of the patterns from the project's .suboignore file.
+// Patterns containing a slash are matched against the path relative to the project root, while
+// patterns without one are matched against the directory's name at any depth, as with .gitignore.
+func (f *moduleFinder) isIgnored(dirPath string) bool {
+	for _, pattern := range f.ignore {
+		target := dirPath
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(dirPath)
+		}
+
+		if matched, _ := path.Match(strings.TrimPrefix(pattern, "/"), target); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readIgnoreFile reads the glob patterns from a .suboignore file at the root of fsys, if one exists.
+func readIgnoreFile(fsys fs.FS) ([]string, error) {
+	ignoreBytes, err := fs.ReadFile(fsys, ".suboignore")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to ReadFile .suboignore")
+	}
+
+	patterns := []string{}
+
+	for _, line := range strings.Split(string(ignoreBytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		patterns = append(patterns, strings.TrimSuffix(line, "/"))
+	}
+
+	return patterns, nil
+}
+
+// ContainsModuleManifest finds any .module manifest (.module.yaml, .module.json, etc.) in a list of files.
+// A bare .module file with no extension is also recognised, and is parsed as YAML. See DefaultModuleManifestPattern.
+func ContainsModuleManifest(files []os.FileInfo) (string, bool) {
+	manifests := moduleManifests(DefaultModuleManifestPattern, files)
+	if len(manifests) == 0 {
+		return "", false
+	}
+
+	return manifests[0], true
+}
+
+// moduleManifests returns the names of every module manifest in files, see isModuleManifest.
+func moduleManifests(pattern string, files []os.FileInfo) []string {
+	manifests := []string{}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		if isModuleManifest(pattern, f.Name()) {
+			manifests = append(manifests, f.Name())
+		}
+	}
+
+	return manifests
+}
+
+// isModuleManifest returns true if name is a bare .module file or matches pattern.
+func isModuleManifest(pattern, name string) bool {
+	if name == ".module" {
+		return true
+	}
+
+	matched, err := filepath.Match(pattern, name)
+
+	return err == nil && matched
+}
+
+// ContainsModuleYaml finds any .module file in a list of files.
+//
+// Deprecated: use ContainsModuleManifest, which this is an alias of.
+func ContainsModuleYaml(files []os.FileInfo) (string, bool) {
+	return ContainsModuleManifest(files)
+}
+
+// unmarshalManifest decodes a module manifest into v, choosing JSON, TOML, or YAML based on the file extension.
+// Any other extension, including none, is decoded as YAML.
+func unmarshalManifest(filename string, data []byte, v interface{}) error {
+	switch filepath.Ext(filename) {
+	case ".json":
+		return json.Unmarshal(data, v)
+	case ".toml":
+		// tenant.Module only has yaml and json tags, so TOML is decoded
+		// generically and then mapped onto v using its json tags.
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return err
+		}
+
+		jsonBytes, err := json.Marshal(tree.ToMap())
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(jsonBytes, v)
+	default:
+		return yaml.Unmarshal(data, v)
+	}
+}
+
+// validateModuleName returns an error if name is not made of ASCII letters, numbers, '.', '-' and '_', since it
+// becomes the filename of the module's .wasm file, its path within the bundle, and part of its build commands.
+func validateModuleName(name string) error {
+	if strings.Trim(strings.Replace(name, "-", "_", -1), "_.") == "" {
+		return fmt.Errorf("%q must contain at least one letter or number", name)
+	}
+
+	for _, r := range name {
+		isAlphanumeric := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+
+		if !isAlphanumeric && r != '.' && r != '-' && r != '_' {
+			return fmt.Errorf("%q must contain only letters, numbers, '.', '-' and '_'", name)
+		}
+	}
+
+	return nil
+}
+
+// validateNamespace returns an error if namespace is not made of lowercase letters, numbers, '-' and '_',
+// starting with a letter or number, since it becomes a directory within the bundle.
+func validateNamespace(namespace string) error {
+	for i, r := range namespace {
+		isAlphanumeric := (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
+
+		if !isAlphanumeric && (i == 0 || (r != '-' && r != '_')) {
+			return fmt.Errorf("%q must contain only lowercase letters, numbers, '-' and '_', and start with a letter or number", namespace)
+		}
+	}
+
+	return nil
+}
+
+// UnsupportedLangError is returned when a module's lang is not supported and it does not specify a buildImage.
+type UnsupportedLangError struct {
+	Module string
+	Lang   string
+}
+
+func (e *UnsupportedLangError) Error() string {
+	return fmt.Sprintf("(%s) %s is not a valid lang and no buildImage was provided, supported langs are: %s", e.Module, e.Lang, strings.Join(validLangList(), ", "))
+}
+
+// validLangList returns the sorted list of valid languages.
+func validLangList() []string {
+	langs := make([]string, 0, len(validLangs))
+	for lang := range validLangs {
+		langs = append(langs, lang)
+	}
+
+	sort.Strings(langs)
+
+	return langs
+}
+
+// CanonicalLang returns the canonical name of lang, ignoring case and resolving aliases such as rs for rust
+// (see langAliases). Languages that are not known are returned unchanged, since a module with a custom
+// buildImage can use any language.
+func CanonicalLang(lang string) string {
+	lower := strings.ToLower(strings.TrimSpace(lang))
+
+	if _, exists := validLangs[lower]; exists {
+		return lower
+	}
+
+	if canonical, exists := langAliases[lower]; exists {
+		return canonical
+	}
+
+	return lang
+}
+
+// IsValidLang returns true if a language is valid.
+func IsValidLang(lang string) bool {
+	_, exists := validLangs[lang]
+
+	return exists
+}
+
+// wantsLang returns true if modules of the given language should be discovered.
+func (f *moduleFinder) wantsLang(lang string) bool {
+	if len(f.langs) == 0 {
+		return true
+	}
+
+	for _, l := range f.langs {
+		if CanonicalLang(l) == lang {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getModuleFromFiles returns the module described by the manifest in files, or nil if there is no
+// manifest or the module's language is not one being discovered.
+func (f *moduleFinder) getModuleFromFiles(wd string, files []os.FileInfo) (*ModuleDir, error) {
+	manifests := moduleManifests(f.opts.manifestPattern(), files)
+	if len(manifests) == 0 {
+		return nil, nil
+	}
+
+	absolutePath, err := f.fullpath(wd)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Abs filepath")
+	}
+
+	// only one manifest is ever read, so edits to any other would silently have no effect.
+	if len(manifests) > 1 {
+		return nil, fmt.Errorf("%s contains more than one module manifest (%s), remove all but one", absolutePath, strings.Join(manifests, ", "))
+	}
+
+	filename := manifests[0]
+
+	modulePath := filepath.Join(absolutePath, filename)
+
+	moduleBytes, err := fs.ReadFile(f.fsys, path.Join(wd, filename))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to ReadFile %s", modulePath)
+	}
+
+	return f.moduleFromManifest(wd, absolutePath, filename, modulePath, moduleBytes)
+}
+
+// moduleFromManifest parses moduleBytes as the module manifest named filename (whose extension sets its format)
+// for the module in wd, whose full path is absolutePath. modulePath names the manifest in errors. A nil ModuleDir
+// is returned if the module should be skipped.
+func (f *moduleFinder) moduleFromManifest(wd, absolutePath, filename, modulePath string, moduleBytes []byte) (*ModuleDir, error) {
+	// yaml and json errors include the offending line or offset, so wrapping them
+	// with the file's path is enough to point the user at the exact problem.
+	module := &tenant.Module{}
+	if err := unmarshalManifest(filename, moduleBytes, module); err != nil {
+		return nil, errors.Wrapf(err, "failed to Unmarshal %s", modulePath)
+	}
+
+	module.Lang = CanonicalLang(module.Lang)
+
+	if !f.wantsLang(module.Lang) {
+		logDebug(fmt.Sprintf("skipping module in %s, its lang %s was not requested", modulePath, module.Lang))
+		return nil, nil
+	}
+
+	if module.Name == "" {
+		module.Name = filepath.Base(absolutePath)
+	}
+
+	if module.Namespace == "" {
+		module.Namespace = f.defaultNamespace(wd)
+	}
+
+	if err := validateModuleName(module.Name); err != nil {
+		return nil, errors.Wrapf(err, "invalid module name in %s", modulePath)
+	}
+
+	if err := validateNamespace(module.Namespace); err != nil {
+		return nil, errors.Wrapf(err, "invalid namespace in %s", modulePath)
+	}
+
+	ext := &moduleExtensions{}
+	if err := unmarshalManifest(filename, moduleBytes, ext); err != nil {
+		return nil, errors.Wrapf(err, "failed to Unmarshal %s", modulePath)
+	}
+
+	if ext.Version != "" {
+		if _, err := version.NewSemver(ext.Version); err != nil {
+			return nil, errors.Wrapf(err, "(%s) version %s is not a valid semantic version", module.Name, ext.Version)
+		}
+	}
+
+	for _, flag := range ext.BuildFlags {
+		if flag == "" || strings.ContainsAny(flag, "\x00\r\n") {
+			return nil, fmt.Errorf("(%s) build flag %q must be non-empty and on a single line", module.Name, flag)
+		}
+	}
+
+	for _, artifact := range ext.Artifacts {
+		if _, err := filepath.Match(artifact, ""); err != nil {
+			return nil, errors.Wrapf(err, "(%s) artifact %s is not a valid pattern", module.Name, artifact)
+		}
+
+		if clean := filepath.Clean(artifact); filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("(%s) artifact %s must be within the module directory", module.Name, artifact)
+		}
+	}
+
+	if ext.BuildImageDigest != "" {
+		if ext.BuildImage == "" {
+			return nil, fmt.Errorf("(%s) buildImageDigest can only be set along with buildImage", module.Name)
+		}
+
+		if err := ValidateImageDigest(ext.BuildImageDigest); err != nil {
+			return nil, errors.Wrapf(err, "(%s) invalid buildImageDigest", module.Name)
+		}
+	}
+
+	// a custom build image can build languages subo doesn't know about.
+	if ok := IsValidLang(module.Lang); !ok && ext.BuildImage == "" {
+		langErr := &UnsupportedLangError{Module: module.Name, Lang: module.Lang}
+		if f.warnOnUnknownLang {
+			logWarn(fmt.Sprintf("skipping module: %s", langErr.Error()))
+			return nil, nil
+		}
+
+		return nil, langErr
+	}
+
+	// the module's sources live alongside its manifest unless otherwise specified.
+	sourceDir := absolutePath
+	if ext.SourceDir != "" {
+		sourceDir = filepath.Join(absolutePath, ext.SourceDir)
+
+		if info, err := fs.Stat(f.fsys, path.Join(wd, filepath.ToSlash(ext.SourceDir))); err != nil {
+			return nil, errors.Wrapf(err, "(%s) failed to Stat sourceDir", module.Name)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("(%s) sourceDir %s is not a directory", module.Name, ext.SourceDir)
+		}
+	}
+
+	// the module's .env adds to its buildEnv, with the same precedence of the OS environment as the project's .env.
+	dotEnv, err := readDotEnv(f.fsys, wd)
+	if err != nil {
+		return nil, errors.Wrapf(err, "(%s) failed to readDotEnv", module.Name)
 	}
 
 	moduleDir := &ModuleDir{
-		Name:           module.Name,
-		UnderscoreName: strings.Replace(module.Name, "-", "_", -1),
-		Fullpath:       absolutePath,
-		Module:         module,
+		Name:             module.Name,
+		UnderscoreName:   strings.Replace(module.Name, "-", "_", -1),
+		Fullpath:         absolutePath,
+		Module:           module,
+		BuildImage:       ext.BuildImage,
+		BuildImageDigest: ext.BuildImageDigest,
+		Version:          ext.Version,
+		BuildEnv:         mergeDotEnv(ext.BuildEnv, dotEnv),
+		BuildFlags:       ext.BuildFlags,
+		Artifacts:        ext.Artifacts,
+		Description:      ext.Description,
+		Labels:           ext.Labels,
+		SourceDir:        sourceDir,
+		Prereqs:          ext.Prereqs,
 	}
 
 	return moduleDir, nil
 }
 
-func bundleTargetPath(cwd string) (*BundleRef, error) {
-	path := filepath.Join(cwd, "modules.wasm.zip")
+func bundleTargetPath(cwd, bundleName string) (*BundleRef, error) {
+	path := filepath.Join(cwd, bundleName)
 
 	b := &BundleRef{
 		Fullpath: path,
 		Exists:   false,
 	}
 
-	_, err := os.Stat(path)
+	info, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return b, nil
@@ -312,6 +2151,7 @@ func bundleTargetPath(cwd string) (*BundleRef, error) {
 	}
 
 	b.Exists = true
+	b.ModTime = info.ModTime()
 
 	return b, nil
 }
@@ -0,0 +1,1550 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/subo/release"
+	"github.com/suborbital/subo/subo/util"
+	"github.com/suborbital/systemspec/tenant"
+	"github.com/suborbital/systemspec/tenant/executable"
+)
+
+// writeModuleDir creates a directory named name inside root containing a .module.yaml with the given contents.
+func writeModuleDir(t *testing.T, root, name, moduleYaml string) string {
+	t.Helper()
+
+	dir := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(dir, util.PermDirectory))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".module.yaml"), []byte(moduleYaml), util.PermFile))
+
+	return dir
+}
+
+func TestGetModuleFromFiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		moduleYaml string
+		wantLang   string
+		wantErr    assert.ErrorAssertionFunc
+	}{
+		{
+			name:       "parses a go module",
+			moduleYaml: "name: hello-go\nlang: go\n",
+			wantLang:   "go",
+			wantErr:    assert.NoError,
+		},
+		{
+			name:       "errors with the file path and line for malformed yaml",
+			moduleYaml: "name: broken\nlang: [rust\n",
+			wantErr: func(t assert.TestingT, err error, _ ...interface{}) bool {
+				return assert.ErrorContains(t, err, ".module.yaml") && assert.ErrorContains(t, err, "line")
+			},
+		},
+		{
+			name:       "errors for an unknown lang",
+			moduleYaml: "name: hello-cobol\nlang: cobol\n",
+			wantErr: func(t assert.TestingT, err error, _ ...interface{}) bool {
+				var langErr *UnsupportedLangError
+				return assert.ErrorAs(t, err, &langErr) && assert.Equal(t, "cobol", langErr.Lang)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModuleDir(t, t.TempDir(), "mod", tt.moduleYaml)
+
+			files, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+
+			got, err := (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, tt.wantLang, got.Module.Lang)
+			assert.Equal(t, "default", got.Module.Namespace)
+			assert.Equal(t, dir, got.Fullpath)
+		})
+	}
+}
+
+func TestBundleTargetPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		bundleName string
+		create     bool
+		wantExists bool
+	}{
+		{
+			name:       "detects an existing default bundle",
+			bundleName: DefaultBundleName,
+			create:     true,
+			wantExists: true,
+		},
+		{
+			name:       "detects an existing custom bundle",
+			bundleName: "prod.wasm.zip",
+			create:     true,
+			wantExists: true,
+		},
+		{
+			name:       "reports a missing custom bundle",
+			bundleName: "prod.wasm.zip",
+			create:     false,
+			wantExists: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if tt.create {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, tt.bundleName), []byte{}, util.PermFile))
+			}
+
+			got, err := bundleTargetPath(dir, tt.bundleName)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantExists, got.Exists)
+			assert.Equal(t, filepath.Join(dir, tt.bundleName), got.Fullpath)
+		})
+	}
+}
+
+func TestContext_UsedLangs(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "one", "name: one\nlang: tinygo\n")
+	writeModuleDir(t, root, "two", "name: two\nlang: rust\n")
+	writeModuleDir(t, root, "three", "name: three\nlang: rust\n")
+
+	ctx, err := ForDirectory(root)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"rust", "tinygo"}, ctx.UsedLangs())
+}
+
+func TestGetModuleDirs_Nested(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "top", "name: top\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("services", "auth", "login"), "name: login\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("node_modules", "dep"), "name: dep\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("a", "b", "c", "d", "e", "too-deep"), "name: too-deep\nlang: rust\n")
+
+	modules, cwdIsModule, err := getModuleDirs(root, Options{})
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, m := range modules {
+		names = append(names, m.Name)
+	}
+
+	assert.False(t, cwdIsModule)
+	assert.ElementsMatch(t, []string{"top", "login"}, names)
+}
+
+func TestForDirectory_BuilderTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		envTag string
+		want   string
+	}{
+		{
+			name:   "defaults to the subo version",
+			envTag: "",
+			want:   "v" + release.SuboVersion,
+		},
+		{
+			name:   "honors SUBO_BUILDER_TAG",
+			envTag: "dev",
+			want:   "dev",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(builderTagEnvKey, tt.envTag)
+
+			ctx, err := ForDirectory(t.TempDir())
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, ctx.BuilderTag)
+		})
+	}
+}
+
+func TestContext_DuplicateModuleNames(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "one", "name: foo\nlang: rust\n")
+	writeModuleDir(t, root, "two", "name: foo\nlang: tinygo\n")
+	writeModuleDir(t, root, "three", "name: foo\nnamespace: auth\nlang: rust\n")
+	writeModuleDir(t, root, "four", "name: hello-world\nlang: rust\n")
+	writeModuleDir(t, root, "five", "name: hello_world\nlang: rust\n")
+
+	ctx, err := ForDirectory(root)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"default::foo", "default::hello-world", "default::hello_world"}, ctx.DuplicateModuleNames())
+}
+
+func TestModuleDir_IdentifierName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"hello", "hello"},
+		{"hello-world", "hello_world"},
+		{"hello..world", "hello_world"},
+		{"hello-_-world", "hello_world"},
+		{"1-hello", "_1_hello"},
+		{"héllo", "h_llo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mod := &ModuleDir{Name: tt.name}
+			assert.Equal(t, tt.want, mod.IdentifierName())
+		})
+	}
+}
+
+func TestModuleDir_ArtifactPaths(t *testing.T) {
+	root := t.TempDir()
+	dir := writeModuleDir(t, root, "dump", "name: dump\nlang: rust\nartifacts:\n  - dump.wat\n  - debug/*.dwarf\n")
+
+	ctx, err := ForDirectory(root)
+	require.NoError(t, err)
+	require.Len(t, ctx.Modules, 1)
+
+	_, err = ctx.ModuleArtifacts()
+	assert.ErrorContains(t, err, "artifact dump.wat was not produced")
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "debug"), util.PermDirectory))
+	for _, file := range []string{"dump.wasm", "dump.wat", filepath.Join("debug", "dump.dwarf")} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte{}, util.PermFile))
+	}
+
+	artifacts, err := ctx.ModuleArtifacts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "dump.wasm"),
+		filepath.Join(dir, "dump.wat"),
+		filepath.Join(dir, "debug", "dump.dwarf"),
+	}, artifacts["default::dump"])
+
+	// a module with the same name in another namespace has its own entry.
+	other := writeModuleDir(t, t.TempDir(), "dump", "name: dump\nlang: rust\nnamespace: auth\n")
+	ctx.Modules = append(ctx.Modules, ModuleDir{Name: "dump", Fullpath: other, Module: &tenant.Module{Namespace: "auth"}})
+
+	artifacts, err = ctx.ModuleArtifacts()
+	require.NoError(t, err)
+	assert.Len(t, artifacts, 2)
+	assert.Equal(t, []string{filepath.Join(other, "dump.wasm")}, artifacts["auth::dump"])
+
+	require.NoError(t, ctx.Clean())
+	assert.NoFileExists(t, filepath.Join(dir, "dump.wat"))
+	assert.NoFileExists(t, filepath.Join(dir, "debug", "dump.dwarf"))
+
+	writeModuleDir(t, root, "escape", "name: escape\nlang: rust\nartifacts:\n  - ../outside.wat\n")
+
+	_, err = ForDirectory(root)
+	assert.ErrorContains(t, err, "must be within the module directory")
+}
+
+func TestGetModuleFromFiles_JSON(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".module.json"), []byte(`{"name": "hello-json", "lang": "rust"}`), util.PermFile))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	got, err := (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello-json", got.Name)
+	assert.Equal(t, "rust", got.Module.Lang)
+	assert.Equal(t, "default", got.Module.Namespace)
+}
+
+func TestGetModuleFromFiles_TOML(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "lang = \"rust\"\napiVersion = \"0.15.1\"\nsourceDir = \"src\"\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".module.toml"), []byte(manifest), util.PermFile))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "src"), util.PermDirectory))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	got, err := (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Base(dir), got.Name)
+	assert.Equal(t, "rust", got.Module.Lang)
+	assert.Equal(t, "0.15.1", got.Module.APIVersion)
+	assert.Equal(t, "default", got.Module.Namespace)
+	assert.Equal(t, filepath.Join(dir, "src"), got.SourceDir)
+}
+
+func TestValidateModuleName(t *testing.T) {
+	tests := []struct {
+		name    string
+		modName string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"plain", "hello-world", assert.NoError},
+		{"underscores and dots", "hello_world.v2", assert.NoError},
+		{"slash", "auth/login", assert.Error},
+		{"backslash", `auth\login`, assert.Error},
+		{"space", "hello world", assert.Error},
+		{"tab", "hello\tworld", assert.Error},
+		{"only separators", "-_-", assert.Error},
+		{"dot dot", "..", assert.Error},
+		{"semicolon", "hello;rm -rf ~", assert.Error},
+		{"dollar", "hello$HOME", assert.Error},
+		{"ampersand", "hello&world", assert.Error},
+		{"quote", "hello'world", assert.Error},
+		{"backtick", "hello`id`", assert.Error},
+		{"non-ascii", "héllo", assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.wantErr(t, validateModuleName(tt.modName))
+		})
+	}
+}
+
+func TestValidateNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		wantErr   assert.ErrorAssertionFunc
+	}{
+		{"default", "default", assert.NoError},
+		{"dashes and underscores", "team-a_v2", assert.NoError},
+		{"leading number", "2fa", assert.NoError},
+		{"uppercase", "Auth", assert.Error},
+		{"space and punctuation", "My Namespace!", assert.Error},
+		{"slash", "auth/login", assert.Error},
+		{"leading dash", "-auth", assert.Error},
+		{"dots", "..", assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.wantErr(t, validateNamespace(tt.namespace))
+		})
+	}
+}
+
+func TestModuleDir_NeedsRebuild(t *testing.T) {
+	dir := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: rust\n")
+	srcPath := filepath.Join(dir, "lib.rs")
+	wasmPath := filepath.Join(dir, "mod.wasm")
+	require.NoError(t, ioutil.WriteFile(srcPath, []byte{}, util.PermFile))
+
+	md := &ModuleDir{Name: "mod", Fullpath: dir}
+
+	needsRebuild, err := md.NeedsRebuild()
+	require.NoError(t, err)
+	assert.True(t, needsRebuild, "missing wasm should need a rebuild")
+
+	older := time.Now().Add(-time.Hour)
+	require.NoError(t, ioutil.WriteFile(wasmPath, []byte{}, util.PermFile))
+	require.NoError(t, os.Chtimes(srcPath, older, older))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, ".module.yaml"), older, older))
+
+	needsRebuild, err = md.NeedsRebuild()
+	require.NoError(t, err)
+	assert.False(t, needsRebuild, "wasm newer than sources should not need a rebuild")
+
+	require.NoError(t, os.Chtimes(wasmPath, older.Add(-time.Hour), older.Add(-time.Hour)))
+
+	needsRebuild, err = md.NeedsRebuild()
+	require.NoError(t, err)
+	assert.True(t, needsRebuild, "wasm older than sources should need a rebuild")
+}
+
+func TestModuleFile_BundlePath(t *testing.T) {
+	assert.Equal(t, "foo.wasm", ModuleFile{Namespace: "default", Name: "foo"}.BundlePath())
+	assert.Equal(t, "auth/foo.wasm", ModuleFile{Namespace: "auth", Name: "foo"}.BundlePath())
+}
+
+func TestGetModuleDirs_SuboIgnore(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "keep", "name: keep\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("templates", "starter"), "name: starter\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("services", "example"), "name: example\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("services", "real"), "name: real\nlang: rust\n")
+
+	ignore := "# scaffolding\ntemplates/\nexample\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".suboignore"), []byte(ignore), util.PermFile))
+
+	modules, _, err := getModuleDirs(root, Options{})
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, m := range modules {
+		names = append(names, m.Name)
+	}
+
+	assert.ElementsMatch(t, []string{"keep", "real"}, names)
+}
+
+func TestForDirectoryWithOptions_Langs(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "one", "name: one\nlang: rust\n")
+	writeModuleDir(t, root, "two", "name: two\nlang: tinygo\n")
+	writeModuleDir(t, root, "three", "name: three\nlang: notyetsupported\n")
+
+	ctx, err := ForDirectoryWithOptions(root, Options{Langs: []string{"rust"}})
+	require.NoError(t, err)
+
+	require.Len(t, ctx.Modules, 1)
+	assert.Equal(t, "one", ctx.Modules[0].Name)
+	assert.Equal(t, []string{"rust"}, ctx.Langs)
+
+	ctx, err = ForDirectoryWithOptions(root, Options{Langs: []string{"RS"}})
+	require.NoError(t, err)
+
+	require.Len(t, ctx.Modules, 1, "languages are matched by their canonical name")
+	assert.Equal(t, "one", ctx.Modules[0].Name)
+}
+
+func TestContext_CheckRuntimeVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"current", release.RuntimeVersion, false},
+		{"newer", "99.0.0", true},
+		{"invalid", "not-a-version", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &Context{RuntimeVersion: tt.version}
+
+			err := ctx.CheckRuntimeVersion()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestContext_CheckBuilderCompatibility(t *testing.T) {
+	tests := []struct {
+		name           string
+		runtimeVersion string
+		builderTag     string
+		tagsForLang    map[string]string
+		wantErr        string
+	}{
+		{name: "no runtime version", builderTag: "v0.1.0"},
+		{name: "current", runtimeVersion: release.RuntimeVersion, builderTag: "v" + release.SuboVersion},
+		{name: "unversioned tag", runtimeVersion: release.RuntimeVersion, builderTag: "latest"},
+		{name: "old builders", runtimeVersion: release.RuntimeVersion, builderTag: "v0.1.0", wantErr: "rust (v0.1.0), tinygo (v0.1.0)"},
+		{
+			name:           "old builder for one lang",
+			runtimeVersion: release.RuntimeVersion,
+			builderTag:     "v" + release.SuboVersion,
+			tagsForLang:    map[string]string{"tinygo": "v0.1.0"},
+			wantErr:        "builder images for tinygo (v0.1.0)",
+		},
+		{name: "unknown runtime", runtimeVersion: "99.0.0", builderTag: "v" + release.SuboVersion, wantErr: "no known compatible builder images"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &Context{
+				Modules: []ModuleDir{
+					{Name: "one", Module: &tenant.Module{Lang: "rust"}},
+					{Name: "two", Module: &tenant.Module{Lang: "tinygo"}},
+					{Name: "custom", Module: &tenant.Module{Lang: "rust"}, BuildImage: "example/builder:v0.1.0"},
+				},
+				RuntimeVersion:     tt.runtimeVersion,
+				BuilderTag:         tt.builderTag,
+				BuilderTagsForLang: tt.tagsForLang,
+			}
+
+			err := ctx.CheckBuilderCompatibility()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestContext_Clean(t *testing.T) {
+	root := t.TempDir()
+	built := writeModuleDir(t, root, "built", "name: built\nlang: rust\n")
+	unbuilt := writeModuleDir(t, root, "unbuilt", "name: unbuilt\nlang: rust\n")
+
+	wasmPath := filepath.Join(built, "built.wasm")
+	bundlePath := filepath.Join(root, DefaultBundleName)
+	require.NoError(t, ioutil.WriteFile(wasmPath, []byte{}, util.PermFile))
+	require.NoError(t, ioutil.WriteFile(bundlePath, []byte{}, util.PermFile))
+
+	ctx := &Context{
+		Modules: []ModuleDir{
+			{Name: "built", Fullpath: built},
+			{Name: "unbuilt", Fullpath: unbuilt},
+		},
+		Bundle: BundleRef{Exists: true, Fullpath: bundlePath},
+	}
+
+	require.NoError(t, ctx.Clean())
+
+	assert.NoFileExists(t, wasmPath)
+	assert.NoFileExists(t, bundlePath)
+	assert.False(t, ctx.Bundle.Exists)
+
+	// a bundle that can't be removed (here, a directory that isn't empty) still exists.
+	require.NoError(t, os.MkdirAll(filepath.Join(bundlePath, "nested"), util.PermDirectory))
+	ctx.Bundle.Exists = true
+
+	assert.Error(t, ctx.Clean())
+	assert.True(t, ctx.Bundle.Exists)
+}
+
+func TestContext_ModulesByNamespace(t *testing.T) {
+	mod := func(name, namespace string) ModuleDir {
+		return ModuleDir{Name: name, Module: &tenant.Module{Name: name, Namespace: namespace}}
+	}
+
+	ctx := &Context{Modules: []ModuleDir{mod("hello", ""), mod("login", "auth"), mod("greet", "default"), mod("logout", "auth")}}
+
+	assert.Equal(t, map[string][]ModuleDir{
+		"default": {mod("hello", ""), mod("greet", "default")},
+		"auth":    {mod("login", "auth"), mod("logout", "auth")},
+	}, ctx.ModulesByNamespace())
+}
+
+func TestContext_OrphanedModules(t *testing.T) {
+	root := t.TempDir()
+	dir := writeModuleDir(t, root, "current", "name: current\nlang: rust\n")
+
+	for _, name := range []string{"current.wasm", "current.opt.wasm", "old.wasm", "README.md"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte{}, util.PermFile))
+	}
+
+	ctx := &Context{Modules: []ModuleDir{{Name: "current", Fullpath: dir, Artifacts: []string{"current.opt.wasm"}}}}
+
+	orphans, err := ctx.OrphanedModules()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "old.wasm")}, orphans)
+
+	require.NoError(t, ctx.Clean())
+	assert.NoFileExists(t, filepath.Join(dir, "old.wasm"))
+	assert.FileExists(t, filepath.Join(dir, "README.md"))
+}
+
+func TestDiscoverModules_MapFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello/.module.yaml":                {Data: []byte("name: hello\nlang: rust\n")},
+		"services/auth/.module.yaml":        {Data: []byte("name: auth\nlang: tinygo\nnamespace: auth\n")},
+		"services/auth/nested/.module.yaml": {Data: []byte("name: nested\nlang: rust\n")},
+		"docs/README.md":                    {Data: []byte("# docs\n")},
+	}
+
+	root := filepath.Join(string(filepath.Separator), "virtual", "project")
+
+	modules, cwdIsModule, err := DiscoverModules(fsys, root, Options{})
+	require.NoError(t, err)
+	assert.False(t, cwdIsModule)
+
+	paths := map[string]string{}
+	for _, m := range modules {
+		paths[m.Name] = m.Fullpath
+	}
+
+	assert.Equal(t, map[string]string{
+		"hello": filepath.Join(root, "hello"),
+		"auth":  filepath.Join(root, "services", "auth"),
+	}, paths)
+}
+
+func TestCanonicalLang(t *testing.T) {
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"rust", "rust"},
+		{"AssemblyScript", "assemblyscript"},
+		{"as", "assemblyscript"},
+		{"ts", "typescript"},
+		{"RS", "rust"},
+		{"golang", "go"},
+		{"cobol", "cobol"},
+		{"MyLang", "MyLang"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			assert.Equal(t, tt.want, CanonicalLang(tt.lang))
+		})
+	}
+}
+
+func TestDiscoverModules_LangAlias(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello/.module.yaml": {Data: []byte("name: hello\nlang: AS\n")},
+	}
+
+	modules, _, err := DiscoverModules(fsys, "/virtual/project", Options{})
+	require.NoError(t, err)
+	require.Len(t, modules, 1)
+	assert.Equal(t, "assemblyscript", modules[0].Module.Lang)
+
+	fsys["hello/.module.yaml"] = &fstest.MapFile{Data: []byte("name: hello\nlang: cobol\n")}
+
+	_, _, err = DiscoverModules(fsys, "/virtual/project", Options{})
+	assert.ErrorContains(t, err, "cobol is not a valid lang")
+}
+
+func TestDiscoverModules_ManifestPattern(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello/.module.yaml":          {Data: []byte("name: hello\nlang: rust\n")},
+		"service/service.module.yaml": {Data: []byte("name: service\nlang: rust\n")},
+		"notes/module.yaml":           {Data: []byte("name: notes\nlang: rust\n")},
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{"default", "", []string{"hello"}},
+		{"named manifests", "*.module.*", []string{"hello", "service"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, _, err := DiscoverModules(fsys, "/virtual/project", Options{ManifestPattern: tt.pattern})
+			require.NoError(t, err)
+
+			names := []string{}
+			for _, m := range modules {
+				names = append(names, m.Name)
+			}
+
+			assert.ElementsMatch(t, tt.want, names)
+		})
+	}
+}
+
+func TestDiscoverModules_SearchDepth(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello/.module.yaml":         {Data: []byte("name: hello\nlang: rust\n")},
+		"one/two/three/.module.yaml": {Data: []byte("name: three\nlang: rust\n")},
+	}
+
+	tests := []struct {
+		name  string
+		depth int
+		want  []string
+	}{
+		{"default", 0, []string{"hello", "three"}},
+		{"shallow", 2, []string{"hello"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, _, err := DiscoverModules(fsys, "/virtual/project", Options{SearchDepth: tt.depth})
+			require.NoError(t, err)
+
+			names := []string{}
+			for _, m := range modules {
+				names = append(names, m.Name)
+			}
+
+			assert.ElementsMatch(t, tt.want, names)
+		})
+	}
+}
+
+func TestDiscoverModules_NamespaceFromParentDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello/.module.yaml":           {Data: []byte("name: hello\nlang: rust\n")},
+		"Payments/charge/.module.yaml": {Data: []byte("name: charge\nlang: rust\n")},
+		"teams/auth/.module.yaml":      {Data: []byte("name: auth\nlang: tinygo\nnamespace: identity\n")},
+		"My Team/login/.module.yaml":   {Data: []byte("name: login\nlang: rust\n")},
+		"team.auth/token/.module.yaml": {Data: []byte("name: token\nlang: rust\n")},
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]string
+	}{
+		{"default", Options{}, map[string]string{"hello": "default", "charge": "default", "auth": "identity", "login": "default", "token": "default"}},
+		{"from parent dir", Options{NamespaceFromParentDir: true}, map[string]string{"hello": "default", "charge": "payments", "auth": "identity", "login": "my-team", "token": "team-auth"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, _, err := DiscoverModules(fsys, "/virtual/project", tt.opts)
+			require.NoError(t, err)
+
+			namespaces := map[string]string{}
+			for _, m := range modules {
+				namespaces[m.Name] = m.Module.Namespace
+			}
+
+			assert.Equal(t, tt.want, namespaces)
+		})
+	}
+}
+
+func TestNamespaceFromDirName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"payments", "payments"},
+		{"My Team", "my-team"},
+		{"team.auth", "team-auth"},
+		{"a  --  b", "a-b"},
+		{"_internal", "internal"},
+		{"v2.", "v2"},
+		{"team_2", "team_2"},
+		{"...", "default"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, namespaceFromDirName(tt.name), tt.name)
+		assert.NoError(t, validateNamespace(namespaceFromDirName(tt.name)))
+	}
+}
+
+func TestContext_ModuleSizes(t *testing.T) {
+	root := t.TempDir()
+	small := writeModuleDir(t, root, "small", "name: small\nlang: rust\n")
+	large := writeModuleDir(t, root, "large", "name: large\nlang: rust\n")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(small, "small.wasm"), make([]byte, 10), util.PermFile))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(large, "large.wasm"), make([]byte, 100), util.PermFile))
+
+	ctx := &Context{
+		Modules: []ModuleDir{
+			{Name: "small", Fullpath: small},
+			{Name: "large", Fullpath: large},
+		},
+	}
+
+	sizes, total, err := ctx.ModuleSizes()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"default::small": 10, "default::large": 100}, sizes)
+	assert.Equal(t, int64(110), total)
+
+	// a module with the same name in another namespace has its own entry.
+	other := writeModuleDir(t, t.TempDir(), "small", "name: small\nlang: rust\nnamespace: auth\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(other, "small.wasm"), make([]byte, 20), util.PermFile))
+	ctx.Modules = append(ctx.Modules, ModuleDir{Name: "small", Fullpath: other, Module: &tenant.Module{Namespace: "auth"}})
+
+	sizes, total, err = ctx.ModuleSizes()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"default::small": 10, "default::large": 100, "auth::small": 20}, sizes)
+	assert.Equal(t, int64(130), total)
+
+	ctx.Modules = append(ctx.Modules, ModuleDir{Name: "unbuilt", Fullpath: root})
+
+	_, _, err = ctx.ModuleSizes()
+	assert.ErrorContains(t, err, "unbuilt")
+}
+
+func TestGetModuleDirs_Symlinks(t *testing.T) {
+	vendor := t.TempDir()
+	shared := writeModuleDir(t, vendor, "shared", "name: shared\nlang: rust\n")
+
+	root := t.TempDir()
+	writeModuleDir(t, root, "local", "name: local\nlang: rust\n")
+	require.NoError(t, os.Symlink(shared, filepath.Join(root, "shared")))
+
+	// a link back to the project root must not be followed forever.
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "group"), util.PermDirectory))
+	require.NoError(t, os.Symlink(root, filepath.Join(root, "group", "loop")))
+
+	modules, _, err := getModuleDirs(root, Options{})
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, m := range modules {
+		names = append(names, m.Name)
+	}
+
+	assert.ElementsMatch(t, []string{"local", "shared"}, names)
+}
+
+func TestContext_ToJSON(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "hello", "name: hello\nlang: rust\n")
+
+	ctx, err := ForDirectory(root)
+	require.NoError(t, err)
+
+	contextJSON, err := ctx.ToJSON()
+	require.NoError(t, err)
+
+	decoded := &Context{}
+	require.NoError(t, json.Unmarshal(contextJSON, decoded))
+
+	assert.Equal(t, ctx.Cwd, decoded.Cwd)
+	require.Len(t, decoded.Modules, 1)
+	assert.Equal(t, "hello", decoded.Modules[0].Name)
+	assert.Equal(t, "rust", decoded.Modules[0].Module.Lang)
+	assert.Equal(t, ctx.Bundle, decoded.Bundle)
+}
+
+func TestContext_ShouldBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		langs  []string
+		filter string
+		mod    ModuleDir
+		want   bool
+	}{
+		{"no filters", nil, "", ModuleDir{Name: "auth-login", Module: &tenant.Module{Lang: "rust"}}, true},
+		{"matching glob", nil, "auth-*", ModuleDir{Name: "auth-login", Module: &tenant.Module{Lang: "rust"}}, true},
+		{"non-matching glob", nil, "auth-*", ModuleDir{Name: "billing", Module: &tenant.Module{Lang: "rust"}}, false},
+		{"matching glob, other lang", []string{"tinygo"}, "auth-*", ModuleDir{Name: "auth-login", Module: &tenant.Module{Lang: "rust"}}, false},
+		{"matching glob and lang", []string{"rust"}, "auth-*", ModuleDir{Name: "auth-login", Module: &tenant.Module{Lang: "rust"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &Context{Langs: tt.langs}
+			require.NoError(t, ctx.SetBuildFilter(tt.filter))
+
+			assert.Equal(t, tt.want, ctx.ShouldBuild(tt.mod))
+		})
+	}
+}
+
+func TestContext_SetBuildFilter_Invalid(t *testing.T) {
+	ctx := &Context{}
+
+	assert.Error(t, ctx.SetBuildFilter("auth-[a"))
+	assert.Empty(t, ctx.BuildFilter, "an invalid filter isn't applied")
+}
+
+func TestContext_ShouldBuildLang_Exclude(t *testing.T) {
+	tests := []struct {
+		name    string
+		langs   []string
+		exclude []string
+		lang    string
+		want    bool
+	}{
+		{"no lists", nil, nil, "swift", true},
+		{"excluded", nil, []string{"swift"}, "swift", false},
+		{"not excluded", nil, []string{"swift"}, "rust", true},
+		{"included and excluded", []string{"swift", "rust"}, []string{"swift"}, "swift", false},
+		{"included, other excluded", []string{"swift", "rust"}, []string{"swift"}, "rust", true},
+		{"not included, not excluded", []string{"rust"}, []string{"swift"}, "tinygo", false},
+		{"included by alias", []string{"RS"}, nil, "rust", true},
+		{"excluded by alias", nil, []string{"JS"}, "javascript", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &Context{}
+			ctx.SetBuildLangs(tt.langs)
+			ctx.SetExcludeLangs(tt.exclude)
+
+			assert.Equal(t, tt.want, ctx.ShouldBuildLang(tt.lang))
+		})
+	}
+}
+
+func TestContext_ModuleChecksums(t *testing.T) {
+	dir := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: rust\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mod.wasm"), []byte("hello"), util.PermFile))
+
+	ctx := &Context{Modules: []ModuleDir{{Name: "mod", Fullpath: dir}}}
+
+	checksums, err := ctx.ModuleChecksums()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"default::mod": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}, checksums)
+
+	// a module with the same name in another namespace has its own entry.
+	other := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: rust\nnamespace: auth\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(other, "mod.wasm"), []byte("hello, auth"), util.PermFile))
+	ctx.Modules = append(ctx.Modules, ModuleDir{Name: "mod", Fullpath: other, Module: &tenant.Module{Namespace: "auth"}})
+
+	checksums, err = ctx.ModuleChecksums()
+	require.NoError(t, err)
+	assert.Len(t, checksums, 2)
+	assert.NotEqual(t, checksums["default::mod"], checksums["auth::mod"])
+}
+
+func TestContext_Summary(t *testing.T) {
+	assert.Equal(t, "no modules, tenant config: no, bundle: no, runtime version: any", (&Context{}).Summary())
+
+	ctx := &Context{
+		Modules: []ModuleDir{
+			{Name: "a", Module: &tenant.Module{Lang: "rust"}},
+			{Name: "b", Module: &tenant.Module{Lang: "tinygo"}},
+			{Name: "c", Module: &tenant.Module{Lang: "rust"}},
+		},
+		TenantConfig:   &tenant.Config{},
+		Bundle:         BundleRef{Exists: true},
+		RuntimeVersion: "0.5.0",
+	}
+
+	assert.Equal(t, "3 module(s) (2 rust, 1 tinygo), tenant config: yes, bundle: yes, runtime version: 0.5.0", ctx.Summary())
+}
+
+func TestForDirectoryWithOptions_BundleDir(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "one", "name: one\nlang: rust\n")
+
+	ctx, err := ForDirectoryWithOptions(root, Options{BundleDir: "dist"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "dist", DefaultBundleName), ctx.Bundle.Fullpath)
+	assert.False(t, ctx.Bundle.Exists)
+
+	out := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(out, DefaultBundleName), []byte{}, util.PermFile))
+
+	require.NoError(t, ctx.SetBundleDir(out))
+	assert.Equal(t, filepath.Join(out, DefaultBundleName), ctx.Bundle.Fullpath)
+	assert.True(t, ctx.Bundle.Exists)
+}
+
+func TestContext_ModuleFilesContext(t *testing.T) {
+	dir := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: rust\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mod.wasm"), []byte{}, util.PermFile))
+
+	ctx := &Context{Modules: []ModuleDir{{Name: "mod", Fullpath: dir}}}
+
+	files, err := ctx.ModuleFilesContext(context.Background())
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	closeFiles(files)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ctx.ModuleFilesContext(cancelled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestForDirectoryWithOptions_TenantConfigPath(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "one", "name: one\nlang: rust\n")
+
+	require.NoError(t, os.Mkdir(filepath.Join(root, "deploy"), util.PermDirectory))
+	configJSON := `{"identifier": "com.suborbital.test", "specVersion": 1, "tenantVersion": 3}`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "deploy", "tenant.json"), []byte(configJSON), util.PermFile))
+
+	ctx, err := ForDirectoryWithOptions(root, Options{TenantConfigPath: filepath.Join("deploy", "tenant.json")})
+	require.NoError(t, err)
+	require.NotNil(t, ctx.TenantConfig)
+	assert.Equal(t, "com.suborbital.test", ctx.TenantConfig.Identifier)
+	assert.Equal(t, filepath.Join(root, "deploy", "tenant.json"), ctx.TenantConfigPath)
+	assert.Equal(t, []byte(configJSON), ctx.TenantConfigRaw)
+
+	// the raw bytes are returned from the cache too, and modifying them doesn't affect it.
+	ctx.TenantConfigRaw[0] = '['
+	ctx, err = ForDirectoryWithOptions(root, Options{TenantConfigPath: filepath.Join("deploy", "tenant.json")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte(configJSON), ctx.TenantConfigRaw)
+
+	_, err = ForDirectoryWithOptions(root, Options{TenantConfigPath: "missing.json"})
+	assert.Error(t, err, "an explicit tenant config path must exist")
+
+	ctx, err = ForDirectory(root)
+	require.NoError(t, err)
+	assert.Nil(t, ctx.TenantConfig)
+	assert.Nil(t, ctx.TenantConfigRaw)
+}
+
+func TestReadTenantConfig_Copies(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "tenant.json")
+	configJSON := `{"identifier": "com.suborbital.test", "specVersion": 1, "tenantVersion": 3, "defaultNamespace": {"name": "default", "workflows": [{"name": "hello"}]}, "namespaces": [{"name": "auth"}]}`
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(configJSON), util.PermFile))
+
+	config, _, err := readTenantConfig(configPath)
+	require.NoError(t, err)
+
+	// modifying anything the returned config refers to must not reach the cache.
+	config.Identifier = "com.suborbital.modified"
+	config.DefaultNamespace.Workflows[0].Name = "modified"
+	config.Namespaces[0].Name = "modified"
+	config.Namespaces = append(config.Namespaces, tenant.NamespaceConfig{Name: "added"})
+
+	config, _, err = readTenantConfig(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "com.suborbital.test", config.Identifier)
+	assert.Equal(t, "hello", config.DefaultNamespace.Workflows[0].Name)
+	require.Len(t, config.Namespaces, 1)
+	assert.Equal(t, "auth", config.Namespaces[0].Name)
+}
+
+func TestReadTenantConfig_ParsesOnce(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "tenant.json")
+	configJSON := `{"identifier": "com.suborbital.test", "specVersion": 1, "tenantVersion": 3}`
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(configJSON), util.PermFile))
+
+	_, _, err := readTenantConfig(configPath)
+	require.NoError(t, err)
+
+	info, err := os.Stat(configPath)
+	require.NoError(t, err)
+
+	// an unparseable file with the same size and modification time is never read, the cached config is returned.
+	require.NoError(t, ioutil.WriteFile(configPath, bytes.Repeat([]byte("x"), len(configJSON)), util.PermFile))
+	require.NoError(t, os.Chtimes(configPath, info.ModTime(), info.ModTime()))
+
+	config, raw, err := readTenantConfig(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "com.suborbital.test", config.Identifier)
+	assert.Equal(t, configJSON, string(raw))
+
+	ClearTenantConfigCache()
+
+	_, _, err = readTenantConfig(configPath)
+	assert.Error(t, err, "a cleared cache re-reads the file")
+}
+
+func TestForDirectoryWithOptions_WarnOnUnknownLang(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "known", "name: known\nlang: rust\n")
+	writeModuleDir(t, root, "experimental", "name: experimental\nlang: notyetsupported\n")
+
+	_, err := ForDirectory(root)
+	assert.Error(t, err, "unknown langs should fail by default")
+
+	ctx, err := ForDirectoryWithOptions(root, Options{WarnOnUnknownLang: true})
+	require.NoError(t, err)
+	require.Len(t, ctx.Modules, 1)
+	assert.Equal(t, "known", ctx.Modules[0].Name)
+}
+
+func TestContext_ApplyTenantConfigTransforms(t *testing.T) {
+	ctx := &Context{TenantConfig: &tenant.Config{Identifier: "com.suborbital.app"}}
+
+	ctx.AddTenantConfigTransform(func(cfg *tenant.Config) error {
+		cfg.Identifier += ".staging"
+		return nil
+	})
+
+	ctx.AddTenantConfigTransform(func(cfg *tenant.Config) error {
+		cfg.Identifier += ".eu"
+		return nil
+	})
+
+	require.NoError(t, ctx.ApplyTenantConfigTransforms())
+	assert.Equal(t, "com.suborbital.app.staging.eu", ctx.TenantConfig.Identifier)
+
+	ctx.AddTenantConfigTransform(func(cfg *tenant.Config) error {
+		return errors.New("bad overlay")
+	})
+
+	assert.ErrorContains(t, ctx.ApplyTenantConfigTransforms(), "bad overlay")
+}
+
+func TestContext_TransformedTenantConfig(t *testing.T) {
+	ctx := &Context{TenantConfig: &tenant.Config{Identifier: "com.suborbital.app"}}
+
+	ctx.AddTenantConfigTransform(func(cfg *tenant.Config) error {
+		cfg.Identifier += ".staging"
+		return nil
+	})
+
+	cfg, err := ctx.TransformedTenantConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "com.suborbital.app.staging", cfg.Identifier)
+	assert.Equal(t, "com.suborbital.app", ctx.TenantConfig.Identifier, "the context's own config is not transformed")
+
+	cfg, err = (&Context{}).TransformedTenantConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+}
+
+func TestModuleDir_DependencyLockHash(t *testing.T) {
+	dir := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: javascript\n")
+	md := &ModuleDir{Name: "mod", Fullpath: dir, SourceDir: dir, Module: &tenant.Module{Lang: "javascript"}}
+
+	hash, err := md.DependencyLockHash()
+	require.NoError(t, err)
+	assert.Empty(t, hash, "no lockfile should produce an empty hash")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "yarn.lock"), []byte("hello"), util.PermFile))
+
+	hash, err = md.DependencyLockHash()
+	require.NoError(t, err)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", hash)
+}
+
+func TestModuleDir_SourceHash(t *testing.T) {
+	dir := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: rust\n")
+	md := &ModuleDir{Name: "mod", Fullpath: dir, SourceDir: dir, Module: &tenant.Module{Lang: "rust"}}
+
+	hash, err := md.SourceHash()
+	require.NoError(t, err)
+
+	// build outputs are not sources.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mod.wasm"), []byte("built"), util.PermFile))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "target", "release"), util.PermDirectory))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "target", "release", "mod.wasm"), []byte("built"), util.PermFile))
+
+	unchanged, err := md.SourceHash()
+	require.NoError(t, err)
+	assert.Equal(t, hash, unchanged)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lib.rs"), []byte("fn main() {}"), util.PermFile))
+
+	changed, err := md.SourceHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changed)
+}
+
+func TestForDirectoryPartial(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "mod", "name: mod\nnamespace: default\nlang: rust\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, DefaultTenantConfigFilename), []byte("{not json"), util.PermFile))
+
+	_, err := ForDirectory(root)
+	assert.Error(t, err)
+
+	bctx, errs, err := ForDirectoryPartial(root, Options{})
+	require.NoError(t, err)
+	require.Len(t, errs, 1)
+	assert.Nil(t, bctx.TenantConfig)
+	require.Len(t, bctx.Modules, 1)
+	assert.Equal(t, "mod", bctx.Modules[0].Name)
+}
+
+func TestContext_WriteManifest(t *testing.T) {
+	dir := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: rust\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mod.wasm"), []byte("hello"), util.PermFile))
+
+	ctx := &Context{Modules: []ModuleDir{{Name: "mod", Fullpath: dir, Module: &tenant.Module{Namespace: "default", Lang: "rust"}}}}
+
+	manifestPath := filepath.Join(t.TempDir(), DefaultBuildManifestFilename)
+	require.NoError(t, ctx.WriteManifest(manifestPath))
+
+	manifestJSON, err := ioutil.ReadFile(manifestPath)
+	require.NoError(t, err)
+
+	manifest := BuildManifest{}
+	require.NoError(t, json.Unmarshal(manifestJSON, &manifest))
+	assert.Equal(t, []BuildManifestModule{{
+		Name:      "mod",
+		Namespace: "default",
+		Lang:      "rust",
+		Size:      5,
+		Checksum:  "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}}, manifest.Modules)
+
+	t.Run("same name in different namespaces", func(t *testing.T) {
+		other := writeModuleDir(t, t.TempDir(), "mod", "name: mod\nlang: rust\nnamespace: auth\n")
+		require.NoError(t, ioutil.WriteFile(filepath.Join(other, "mod.wasm"), []byte("hello, auth"), util.PermFile))
+
+		ctx.Modules = append(ctx.Modules, ModuleDir{Name: "mod", Fullpath: other, Module: &tenant.Module{Namespace: "auth", Lang: "rust"}})
+
+		manifest, err := ctx.BuildManifest()
+		require.NoError(t, err)
+		require.Len(t, manifest.Modules, 2)
+		assert.Equal(t, int64(5), manifest.Modules[0].Size)
+		assert.Equal(t, int64(11), manifest.Modules[1].Size)
+		assert.NotEqual(t, manifest.Modules[0].Checksum, manifest.Modules[1].Checksum)
+	})
+}
+
+func TestGetModuleFromFiles_Extensionless(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".module"), []byte("name: hello-bare\nlang: rust\n"), util.PermFile))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	got, err := (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello-bare", got.Name)
+	assert.Equal(t, "rust", got.Module.Lang)
+}
+
+func TestGetModuleFromFiles_MultipleManifests(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".module.yaml"), []byte("name: hello\nlang: rust\n"), util.PermFile))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".module.json"), []byte(`{"name": "hello", "lang": "tinygo"}`), util.PermFile))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	_, err = (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+	assert.ErrorContains(t, err, "more than one module manifest (.module.json, .module.yaml)")
+}
+
+func TestContext_ForEachModule(t *testing.T) {
+	ctx := &Context{
+		Modules: []ModuleDir{
+			{Name: "a", Module: &tenant.Module{Lang: "rust"}},
+			{Name: "b", Module: &tenant.Module{Lang: "tinygo"}},
+			{Name: "c", Module: &tenant.Module{Lang: "rust"}},
+		},
+		Langs: []string{"rust"},
+	}
+
+	visited := []string{}
+	err := ctx.ForEachModule(func(mod ModuleDir) error {
+		visited = append(visited, mod.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, visited)
+
+	stop := errors.New("stop")
+	visited = []string{}
+	err = ctx.ForEachModule(func(mod ModuleDir) error {
+		visited = append(visited, mod.Name)
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []string{"a"}, visited)
+}
+
+func TestBuildEnvFromEnviron(t *testing.T) {
+	env := buildEnvFromEnviron([]string{"SUBO_BUILD_ENV_NPM_TOKEN=abc=123", "SUBO_BUILD_ENV_=ignored", "HOME=/root"})
+
+	assert.Equal(t, map[string]string{"NPM_TOKEN": "abc=123"}, env)
+}
+
+func TestParseDotEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"values", "NPM_TOKEN=abc=123\nEMPTY=\n", map[string]string{"NPM_TOKEN": "abc=123", "EMPTY": ""}, assert.NoError},
+		{"comments and export", "# registry token\n\nexport NPM_TOKEN = abc\n", map[string]string{"NPM_TOKEN": "abc"}, assert.NoError},
+		{"quoted values", "A=\"hello world\"\nB='#not a comment'\n", map[string]string{"A": "hello world", "B": "#not a comment"}, assert.NoError},
+		{"missing equals", "NPM_TOKEN\n", nil, assert.Error},
+		{"missing key", "=abc\n", nil, assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotEnv([]byte(tt.data))
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDiscoverModules_DotEnv(t *testing.T) {
+	t.Setenv("SUBO_TEST_DOTENV_OS", "from-os")
+	t.Setenv("SUBO_BUILD_ENV_SUBO_TEST_DOTENV_PREFIXED", "from-prefix")
+
+	fsys := fstest.MapFS{
+		"hello/.module.yaml": {Data: []byte("name: hello\nlang: rust\nbuildEnv:\n  MANIFEST: from-manifest\n")},
+		"hello/.env":         {Data: []byte("MANIFEST=from-dotenv\nDOTENV=from-dotenv\nSUBO_TEST_DOTENV_OS=from-dotenv\nSUBO_TEST_DOTENV_PREFIXED=from-dotenv\n")},
+		"other/.module.yaml": {Data: []byte("name: other\nlang: rust\n")},
+	}
+
+	modules, _, err := DiscoverModules(fsys, "/virtual/project", Options{})
+	require.NoError(t, err)
+	require.Len(t, modules, 2)
+
+	assert.Equal(t, map[string]string{
+		"MANIFEST":                  "from-manifest",
+		"DOTENV":                    "from-dotenv",
+		"SUBO_TEST_DOTENV_OS":       "from-os",
+		"SUBO_TEST_DOTENV_PREFIXED": "from-prefix",
+	}, modules[0].BuildEnv)
+	assert.Nil(t, modules[1].BuildEnv)
+
+	fsys["hello/.env"] = &fstest.MapFile{Data: []byte("not a variable\n")}
+
+	_, _, err = DiscoverModules(fsys, "/virtual/project", Options{})
+	assert.ErrorContains(t, err, "line 1 is not formatted as KEY=value")
+}
+
+func TestModuleDir_EntryFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		mod     ModuleDir
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"rust", ModuleDir{Name: "hello", Fullpath: "/mod", Module: &tenant.Module{Lang: "rust"}}, filepath.Join("/mod", "src", "lib.rs"), assert.NoError},
+		{"swift uses the module name", ModuleDir{Name: "hello", Fullpath: "/mod", Module: &tenant.Module{Lang: "swift"}}, filepath.Join("/mod", "Sources", "hello", "main.swift"), assert.NoError},
+		{"relative to the source dir", ModuleDir{Name: "hello", Fullpath: "/mod", SourceDir: "/mod/code", Module: &tenant.Module{Lang: "tinygo"}}, filepath.Join("/mod", "code", "main.go"), assert.NoError},
+		{"unknown lang", ModuleDir{Name: "hello", Fullpath: "/mod", Module: &tenant.Module{Lang: "cobol"}}, "", assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mod.EntryFile()
+
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestContext_BundleIsStale(t *testing.T) {
+	root := t.TempDir()
+	dir := writeModuleDir(t, root, "mod", "name: mod\nlang: rust\n")
+	wasmPath := filepath.Join(dir, "mod.wasm")
+	require.NoError(t, ioutil.WriteFile(wasmPath, []byte("hello"), util.PermFile))
+
+	ctx, err := ForDirectory(root)
+	require.NoError(t, err)
+
+	stale, err := ctx.BundleIsStale()
+	require.NoError(t, err)
+	assert.True(t, stale, "a missing bundle should be stale")
+
+	require.NoError(t, ioutil.WriteFile(ctx.Bundle.Fullpath, []byte{}, util.PermFile))
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(wasmPath, past, past))
+	require.NoError(t, ctx.SetBundleDir(""))
+
+	stale, err = ctx.BundleIsStale()
+	require.NoError(t, err)
+	assert.False(t, stale)
+
+	require.NoError(t, os.Chtimes(wasmPath, time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+
+	stale, err = ctx.BundleIsStale()
+	require.NoError(t, err)
+	assert.True(t, stale, "a module built after the bundle should make it stale")
+}
+
+func TestGetModuleFromFiles_Version(t *testing.T) {
+	tests := []struct {
+		name        string
+		moduleYaml  string
+		wantVersion string
+		wantErr     assert.ErrorAssertionFunc
+	}{
+		{"no version", "name: mod\nlang: rust\n", "", assert.NoError},
+		{"semver", "name: mod\nlang: rust\nversion: 1.2.3\n", "1.2.3", assert.NoError},
+		{"not semver", "name: mod\nlang: rust\nversion: latest\n", "", assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModuleDir(t, t.TempDir(), "mod", tt.moduleYaml)
+
+			files, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+
+			got, err := (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, tt.wantVersion, got.Version)
+		})
+	}
+}
+
+func TestGetModuleFromFiles_Metadata(t *testing.T) {
+	manifest := "name: mod\nlang: rust\ndescription: Greets visitors\nlabels:\n  owner: web-team\n  tier: \"1\"\n"
+	dir := writeModuleDir(t, t.TempDir(), "mod", manifest)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	got, err := (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Greets visitors", got.Description)
+	assert.Equal(t, map[string]string{"owner": "web-team", "tier": "1"}, got.Labels)
+
+	modJSON, err := json.Marshal(got)
+	require.NoError(t, err)
+	assert.Contains(t, string(modJSON), `"description":"Greets visitors","labels":{"owner":"web-team","tier":"1"}`)
+}
+
+func TestGetModuleFromFiles_BuildFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		moduleYaml string
+		wantFlags  []string
+		wantErr    assert.ErrorAssertionFunc
+	}{
+		{"no flags", "name: mod\nlang: rust\n", nil, assert.NoError},
+		{"flags", "name: mod\nlang: rust\nbuildFlags:\n  - --features\n  - json,yaml\n", []string{"--features", "json,yaml"}, assert.NoError},
+		{"multi-line flag", "name: mod\nlang: rust\nbuildFlags:\n  - \"--features\\nrm -rf /\"\n", nil, assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModuleDir(t, t.TempDir(), "mod", tt.moduleYaml)
+
+			files, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+
+			got, err := (&moduleFinder{fsys: os.DirFS(dir), root: dir}).getModuleFromFiles(".", files)
+
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, tt.wantFlags, got.BuildFlags)
+		})
+	}
+}
+
+func TestContext_ValidateHandlerChains(t *testing.T) {
+	step := func(name, as string, with map[string]string) executable.Executable {
+		return executable.Executable{ExecutableMod: executable.ExecutableMod{FQMN: "fqmn://tenant/default/" + name, As: as, With: with}}
+	}
+
+	ctx := &Context{
+		Modules: []ModuleDir{{Name: "auth"}, {Name: "greet"}},
+		TenantConfig: &tenant.Config{
+			DefaultNamespace: tenant.NamespaceConfig{
+				Workflows: []tenant.Workflow{
+					{
+						Name:  "ok",
+						Steps: []executable.Executable{step("auth", "user", nil), step("greet", "", map[string]string{"u": "user"})},
+					},
+					{
+						Name:  "broken",
+						Steps: []executable.Executable{step("auth", "", nil), step("gret", "", nil), step("greet", "", map[string]string{"g": "greet"})},
+					},
+					{Name: "ping", Triggers: []tenant.Trigger{{Topic: "a", SinkTopic: "b"}}},
+					{Name: "pong", Triggers: []tenant.Trigger{{Topic: "b", SinkTopic: "a"}}},
+				},
+			},
+		},
+	}
+
+	errs := ctx.ValidateHandlerChains()
+	require.Len(t, errs, 3)
+	assert.ErrorContains(t, errs[0], "workflow broken step 1: references module gret")
+	assert.ErrorContains(t, errs[1], "workflow broken step 2: module greet references its own result")
+	assert.ErrorContains(t, errs[2], "ping -> pong -> ping")
+}
+
+func TestForModule(t *testing.T) {
+	root := t.TempDir()
+	dir := writeModuleDir(t, root, "hello", "name: hello\nlang: rust\n")
+	writeModuleDir(t, root, "sibling", "name: sibling\nlang: cobol\n")
+
+	mod, err := ForModule(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", mod.Name)
+	assert.Equal(t, dir, mod.Fullpath)
+
+	_, err = ForModule(root)
+	assert.ErrorContains(t, err, "no module manifest")
+}
+
+func TestModuleFromReader(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "hello")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), util.PermDirectory))
+
+	tests := []struct {
+		name     string
+		manifest string
+		format   string
+		wantName string
+		wantLang string
+		wantErr  string
+	}{
+		{"yaml", "lang: rs\n", "yaml", "hello", "rust", ""},
+		{"json", `{"name": "greeter", "lang": "tinygo", "sourceDir": "src"}`, "JSON", "greeter", "tinygo", ""},
+		{"invalid manifest", "lang: [rust\n", "yml", "", "", "failed to Unmarshal yml manifest for " + dir},
+		{"missing sourceDir", "lang: rust\nsourceDir: missing\n", "yaml", "", "", "failed to Stat sourceDir"},
+		{"unknown format", "lang: rust\n", "xml", "", "", "xml is not a supported manifest format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mod, err := ModuleFromReader(strings.NewReader(tt.manifest), dir, tt.format)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, mod.Name)
+			assert.Equal(t, tt.wantLang, mod.Module.Lang)
+			assert.Equal(t, dir, mod.Fullpath)
+		})
+	}
+}
+
+func TestWalkModules(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "one", "name: one\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("nested", "two"), "name: two\nlang: rust\n")
+	writeModuleDir(t, root, "three", "name: three\nlang: rust\n")
+
+	names := []string{}
+	err := WalkModules(root, Options{}, func(mod ModuleDir) error {
+		names = append(names, mod.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"one", "two", "three"}, names)
+
+	stop := errors.New("stop")
+	count := 0
+	err = WalkModules(root, Options{}, func(mod ModuleDir) error {
+		count++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestContext_ValidateTenantConfigSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"valid", `{"identifier": "com.suborbital.test", "tenantVersion": 1, "defaultNamespace": {"name": "default"}}`, assert.NoError},
+		{"misspelled top-level key", `{"identifer": "com.suborbital.test"}`, func(t assert.TestingT, err error, _ ...interface{}) bool {
+			return assert.ErrorContains(t, err, `unknown key "identifer", did you mean "identifier"?`)
+		}},
+		{"unknown nested key", `{"defaultNamespace": {"name": "default", "workflow": []}}`, func(t assert.TestingT, err error, _ ...interface{}) bool {
+			return assert.ErrorContains(t, err, `unknown field "workflow"`)
+		}},
+		{"wrong type", `{"tenantVersion": "one"}`, assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), DefaultTenantConfigFilename)
+			require.NoError(t, ioutil.WriteFile(configPath, []byte(tt.config), util.PermFile))
+
+			tt.wantErr(t, (&Context{TenantConfigPath: configPath}).ValidateTenantConfigSchema())
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package project
+
+import (
+	"sort"
+)
+
+// ContextDiff lists the modules that differ between two contexts, by name.
+type ContextDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// Empty returns true if the contexts' modules are the same.
+func (d ContextDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// DiffContexts compares the modules of two contexts, such as those of two commits of the same project.
+// A module is modified if its language or namespace has changed. Either context may be nil, meaning it has no modules.
+func DiffContexts(old, new *Context) ContextDiff {
+	oldMods := modulesByName(old)
+	newMods := modulesByName(new)
+
+	diff := ContextDiff{
+		Added:    []string{},
+		Removed:  []string{},
+		Modified: []string{},
+	}
+
+	for name, newMod := range newMods {
+		oldMod, exists := oldMods[name]
+		if !exists {
+			diff.Added = append(diff.Added, name)
+			continue
+		}
+
+		if moduleLang(oldMod) != moduleLang(newMod) || moduleNamespace(oldMod) != moduleNamespace(newMod) {
+			diff.Modified = append(diff.Modified, name)
+		}
+	}
+
+	for name := range oldMods {
+		if _, exists := newMods[name]; !exists {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+
+	return diff
+}
+
+func modulesByName(ctx *Context) map[string]ModuleDir {
+	mods := map[string]ModuleDir{}
+	if ctx == nil {
+		return mods
+	}
+
+	for _, mod := range ctx.Modules {
+		mods[mod.Name] = mod
+	}
+
+	return mods
+}
+
+func moduleLang(mod ModuleDir) string {
+	if mod.Module == nil {
+		return ""
+	}
+
+	return mod.Module.Lang
+}
+
+func moduleNamespace(mod ModuleDir) string {
+	if mod.Module == nil {
+		return ""
+	}
+
+	return mod.Module.Namespace
+}
@@ -0,0 +1,56 @@
+package project
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/suborbital/systemspec/tenant"
+)
+
+func TestDiffContexts(t *testing.T) {
+	mod := func(name, lang, namespace string) ModuleDir {
+		return ModuleDir{Name: name, Module: &tenant.Module{Name: name, Lang: lang, Namespace: namespace}}
+	}
+
+	old := &Context{Modules: []ModuleDir{
+		mod("auth", "rust", "default"),
+		mod("greet", "swift", "default"),
+		mod("legacy", "rust", "default"),
+		mod("report", "tinygo", "default"),
+	}}
+
+	new := &Context{Modules: []ModuleDir{
+		mod("auth", "rust", "default"),
+		mod("greet", "rust", "default"),
+		mod("report", "tinygo", "reports"),
+		mod("search", "assemblyscript", "default"),
+	}}
+
+	tests := []struct {
+		name     string
+		old, new *Context
+		want     ContextDiff
+	}{
+		{"changes", old, new, ContextDiff{
+			Added:    []string{"search"},
+			Removed:  []string{"legacy"},
+			Modified: []string{"greet", "report"},
+		}},
+		{"unchanged", old, old, ContextDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}},
+		{"no old context", nil, &Context{Modules: []ModuleDir{mod("auth", "rust", "default")}}, ContextDiff{
+			Added:    []string{"auth"},
+			Removed:  []string{},
+			Modified: []string{},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffContexts(tt.old, tt.new)
+
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.old == tt.new, got.Empty())
+		})
+	}
+}
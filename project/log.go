@@ -0,0 +1,62 @@
+package project
+
+import (
+	"sync"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+// LogLevel controls which messages the project package logs.
+type LogLevel int
+
+const (
+	// LogLevelQuiet logs nothing.
+	LogLevelQuiet LogLevel = iota
+	// LogLevelWarn logs warnings, such as directories that could not be read during discovery. This is the default.
+	LogLevelWarn
+	// LogLevelDebug logs warnings, and the decisions made during module discovery.
+	LogLevelDebug
+)
+
+var logConfig = struct {
+	sync.RWMutex
+	level  LogLevel
+	logger util.FriendlyLogger
+}{
+	level:  LogLevelWarn,
+	logger: &util.PrintLogger{},
+}
+
+// SetLogLevel sets the level of messages that the project package logs.
+func SetLogLevel(level LogLevel) {
+	logConfig.Lock()
+	defer logConfig.Unlock()
+
+	logConfig.level = level
+}
+
+// SetLogger sets the logger that the project package logs to, util.PrintLogger by default.
+func SetLogger(logger util.FriendlyLogger) {
+	logConfig.Lock()
+	defer logConfig.Unlock()
+
+	logConfig.logger = logger
+}
+
+func logWarn(msg string) {
+	logConfig.RLock()
+	defer logConfig.RUnlock()
+
+	if logConfig.level >= LogLevelWarn {
+		logConfig.logger.LogWarn(msg)
+	}
+}
+
+func logDebug(msg string) {
+	logConfig.RLock()
+	defer logConfig.RUnlock()
+
+	if logConfig.level >= LogLevelDebug {
+		logConfig.logger.LogInfo(msg)
+	}
+}
@@ -0,0 +1,56 @@
+package project
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+type recordingLogger struct {
+	util.PrintLogger
+	infos []string
+	warns []string
+}
+
+func (r *recordingLogger) LogInfo(msg string) { r.infos = append(r.infos, msg) }
+func (r *recordingLogger) LogWarn(msg string) { r.warns = append(r.warns, msg) }
+
+func TestSetLogLevel(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, "parent", "name: parent\nlang: rust\n")
+	writeModuleDir(t, root, filepath.Join("parent", "child"), "name: child\nlang: rust\n")
+
+	tests := []struct {
+		name      string
+		level     LogLevel
+		wantWarns int
+		wantInfos bool
+	}{
+		{"quiet", LogLevelQuiet, 0, false},
+		{"warn", LogLevelWarn, 1, false},
+		{"debug", LogLevelDebug, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			SetLogger(logger)
+			SetLogLevel(tt.level)
+
+			t.Cleanup(func() {
+				SetLogger(&util.PrintLogger{})
+				SetLogLevel(LogLevelWarn)
+			})
+
+			_, err := ForDirectory(filepath.Join(root, "parent"))
+			require.NoError(t, err)
+
+			assert.Len(t, logger.warns, tt.wantWarns)
+			assert.Equal(t, tt.wantInfos, len(logger.infos) > 0)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ForDirectories returns a single build context containing the modules discovered in each of dirs,
+// such as several repositories checked out side by side. The first directory is the working directory
+// of the context, so the bundle is written there. At most one of the directories may contain a tenant config,
+// and modules in different directories must not have the same name within a namespace. Each directory is
+// recorded in the context's Roots, a builder container only mounts one directory, so when there is more than
+// one the context can only be built with the native toolchain.
+func ForDirectories(dirs []string) (*Context, error) {
+	return ForDirectoriesWithOptions(dirs, Options{})
+}
+
+// ForDirectoriesWithOptions is ForDirectories with each directory's context configured by opts.
+func ForDirectoriesWithOptions(dirs []string, opts Options) (*Context, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("at least one directory is required")
+	}
+
+	var merged *Context
+
+	for _, dir := range dirs {
+		bctx, err := ForDirectoryWithOptions(dir, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to ForDirectoryWithOptions %s", dir)
+		}
+
+		if merged == nil {
+			merged = bctx
+			merged.Roots = []string{bctx.Cwd}
+			continue
+		}
+
+		merged.Roots = append(merged.Roots, bctx.Cwd)
+
+		if bctx.TenantConfig != nil {
+			if merged.TenantConfig != nil {
+				return nil, fmt.Errorf("found tenant configs at both %s and %s, only one is allowed", merged.TenantConfigPath, bctx.TenantConfigPath)
+			}
+
+			merged.TenantConfig = bctx.TenantConfig
+			merged.TenantConfigPath = bctx.TenantConfigPath
+			merged.TenantConfigRaw = bctx.TenantConfigRaw
+		}
+
+		merged.Modules = append(merged.Modules, bctx.Modules...)
+		merged.CwdIsModule = false
+	}
+
+	if dupes := merged.DuplicateModuleNames(); len(dupes) > 0 {
+		return nil, fmt.Errorf("found more than one module with the same name in the same namespace: %s", strings.Join(dupes, ", "))
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,65 @@
+package project
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+func TestForDirectories(t *testing.T) {
+	configJSON := []byte(`{"identifier": "com.suborbital.test", "specVersion": 1, "tenantVersion": 1}`)
+
+	first, second := t.TempDir(), t.TempDir()
+	writeModuleDir(t, first, "auth", "name: auth\nlang: rust\n")
+	writeModuleDir(t, second, "greet", "name: greet\nlang: swift\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(second, DefaultTenantConfigFilename), configJSON, util.PermFile))
+
+	ctx, err := ForDirectories([]string{first, second})
+	require.NoError(t, err)
+
+	assert.Equal(t, first, ctx.Cwd)
+	assert.True(t, ctx.ModuleExists("auth"))
+	assert.True(t, ctx.ModuleExists("greet"))
+	require.NotNil(t, ctx.TenantConfig)
+	assert.Equal(t, filepath.Join(second, DefaultTenantConfigFilename), ctx.TenantConfigPath)
+	assert.Equal(t, []string{first, second}, ctx.Roots)
+
+	// modules from every directory keep their own paths.
+	greet := ctx.Modules[1]
+	assert.Equal(t, "greet", greet.Name)
+	assert.Equal(t, filepath.Join(second, "greet"), greet.Fullpath)
+	assert.Equal(t, filepath.Join(second, "greet"), greet.SourceDir)
+
+	t.Run("name collision", func(t *testing.T) {
+		other := t.TempDir()
+		writeModuleDir(t, other, "auth", "name: auth\nlang: tinygo\n")
+
+		_, err := ForDirectories([]string{first, other})
+		assert.ErrorContains(t, err, "default::auth")
+	})
+
+	t.Run("more than one tenant config", func(t *testing.T) {
+		other := t.TempDir()
+		require.NoError(t, ioutil.WriteFile(filepath.Join(other, DefaultTenantConfigFilename), configJSON, util.PermFile))
+
+		_, err := ForDirectories([]string{second, other})
+		assert.ErrorContains(t, err, "only one is allowed")
+	})
+
+	t.Run("options apply to every directory", func(t *testing.T) {
+		ctx, err := ForDirectoriesWithOptions([]string{first, second}, Options{Langs: []string{"swift"}})
+		require.NoError(t, err)
+		require.Len(t, ctx.Modules, 1)
+		assert.Equal(t, "greet", ctx.Modules[0].Name)
+	})
+
+	t.Run("no directories", func(t *testing.T) {
+		_, err := ForDirectories(nil)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,80 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// configFilesForLang are the project files each language's toolchain needs to build a module,
+// relative to the source directory.
+var configFilesForLang = map[string][]string{
+	"rust":           {"Cargo.toml"},
+	"swift":          {"Package.swift"},
+	"assemblyscript": {"package.json"},
+	"typescript":     {"package.json"},
+	"javascript":     {"package.json"},
+	"tinygo":         {"go.mod"},
+	"go":             {"go.mod"},
+}
+
+// PreflightSources checks that every module that should be built has its entry file and the project files its
+// language requires, such as Cargo.toml or package.json, so that a build can fail fast with a clear message
+// rather than an error from the compiler. One error is returned for each module that is missing something.
+func (b *Context) PreflightSources() []error {
+	var errs []error
+
+	_ = b.ForEachModule(func(mod ModuleDir) error {
+		if err := mod.preflightSources(); err != nil {
+			errs = append(errs, err)
+		}
+
+		return nil
+	})
+
+	return errs
+}
+
+// preflightSources returns an error listing the module's missing source files, if there are any.
+// Modules in a language without a known layout are not checked.
+func (m *ModuleDir) preflightSources() error {
+	sourceDir := m.SourceDir
+	if sourceDir == "" {
+		sourceDir = m.Fullpath
+	}
+
+	var expected []string
+
+	if entry, err := m.EntryFile(); err == nil {
+		expected = append(expected, entry)
+	}
+
+	for _, file := range configFilesForLang[m.Module.Lang] {
+		expected = append(expected, filepath.Join(sourceDir, file))
+	}
+
+	var missing []string
+
+	for _, file := range expected {
+		if _, err := os.Stat(file); err != nil {
+			if !os.IsNotExist(err) {
+				return errors.Wrapf(err, "(%s) failed to Stat %s", m.Name, file)
+			}
+
+			if rel, err := filepath.Rel(sourceDir, file); err == nil {
+				file = rel
+			}
+
+			missing = append(missing, file)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("(%s) %s module is missing %s", m.Name, m.Module.Lang, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+func TestContext_PreflightSources(t *testing.T) {
+	root := t.TempDir()
+
+	writeFile := func(path string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), util.PermDirectory))
+		require.NoError(t, ioutil.WriteFile(path, []byte{}, util.PermFile))
+	}
+
+	complete := writeModuleDir(t, root, "complete", "name: complete\nlang: rust\n")
+	writeFile(filepath.Join(complete, "Cargo.toml"))
+	writeFile(filepath.Join(complete, "src", "lib.rs"))
+
+	noManifest := writeModuleDir(t, root, "no-manifest", "name: no-manifest\nlang: rust\n")
+	writeFile(filepath.Join(noManifest, "src", "lib.rs"))
+
+	writeModuleDir(t, root, "empty", "name: empty\nlang: tinygo\n")
+
+	ctx, err := ForDirectory(root)
+	require.NoError(t, err)
+
+	errs := ctx.PreflightSources()
+	require.Len(t, errs, 2)
+
+	messages := []string{errs[0].Error(), errs[1].Error()}
+	assert.Contains(t, messages, "(no-manifest) rust module is missing Cargo.toml")
+	assert.Contains(t, messages, "(empty) tinygo module is missing main.go, go.mod")
+
+	ctx.Langs = []string{"rust"}
+	require.NoError(t, ctx.SetBuildFilter("complete"))
+	assert.Empty(t, ctx.PreflightSources(), "modules that will not be built are not checked")
+}
@@ -0,0 +1,191 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ModuleChange describes how a changed path affected a context's modules.
+type ModuleChange int
+
+const (
+	// ModuleUnchanged means the path is not part of any module.
+	ModuleUnchanged ModuleChange = iota
+	// ModuleSourceChanged means the path is one of a module's source files, so the module needs to be rebuilt.
+	ModuleSourceChanged
+	// ModuleManifestChanged means the module's manifest changed, and the module was re-read from it.
+	ModuleManifestChanged
+	// ModuleAdded means a new module manifest was created, and the module was added to the context.
+	ModuleAdded
+	// ModuleRemoved means a module's manifest or directory was deleted, and the module was removed from the context.
+	ModuleRemoved
+)
+
+// Refresh updates the context for a single changed path (such as one reported by a filesystem watcher)
+// without rediscovering every module. Changes to a module manifest re-read only that module, and changes
+// to any other file within a module are reported so that it can be rebuilt. The name of the affected module
+// is returned along with how it changed.
+func (b *Context) Refresh(changedPath string) (string, ModuleChange, error) {
+	path, err := filepath.Abs(changedPath)
+	if err != nil {
+		return "", ModuleUnchanged, errors.Wrap(err, "failed to get Abs path")
+	}
+
+	if isModuleManifest(b.discovery.manifestPattern(), filepath.Base(path)) {
+		return b.refreshManifestDir(filepath.Dir(path))
+	}
+
+	i := b.moduleIndexContaining(path)
+	if i < 0 {
+		return "", ModuleUnchanged, nil
+	}
+
+	name := b.Modules[i].Name
+
+	if path == b.Modules[i].Fullpath {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			b.Modules = append(b.Modules[:i], b.Modules[i+1:]...)
+			return name, ModuleRemoved, nil
+		}
+	}
+
+	return name, ModuleSourceChanged, nil
+}
+
+// refreshManifestDir re-reads the module in dir after its manifest was created, changed or deleted. The module
+// is discovered with the same options as the rest of the context's modules, so it is only added (or kept) if
+// a search of the whole project would find it.
+func (b *Context) refreshManifestDir(dir string) (string, ModuleChange, error) {
+	existing := -1
+
+	for i := range b.Modules {
+		if b.Modules[i].Fullpath == dir {
+			existing = i
+			break
+		}
+	}
+
+	mod, err := b.discoverModuleIn(dir, existing)
+	if err != nil {
+		return "", ModuleUnchanged, errors.Wrap(err, "failed to discoverModuleIn")
+	}
+
+	if mod == nil {
+		if existing < 0 {
+			return "", ModuleUnchanged, nil
+		}
+
+		name := b.Modules[existing].Name
+		b.Modules = append(b.Modules[:existing], b.Modules[existing+1:]...)
+
+		return name, ModuleRemoved, nil
+	}
+
+	for i, other := range b.Modules {
+		if i != existing && other.Module.Namespace == mod.Module.Namespace && other.IdentifierName() == mod.IdentifierName() {
+			return "", ModuleUnchanged, fmt.Errorf("the module in %s has the same name as the module in %s within namespace %s", dir, other.Fullpath, mod.Module.Namespace)
+		}
+	}
+
+	if existing >= 0 {
+		b.Modules[existing] = *mod
+		return mod.Name, ModuleManifestChanged, nil
+	}
+
+	b.Modules = append(b.Modules, *mod)
+
+	return mod.Name, ModuleAdded, nil
+}
+
+// discoverModuleIn returns the module in dir as it would be discovered by searching the context's root that
+// contains dir with the context's discovery options, or nil if such a search would not find a module there.
+// existing is the index of the module currently in dir, or -1 if there is none.
+func (b *Context) discoverModuleIn(dir string, existing int) (*ModuleDir, error) {
+	files, err := readDir(os.DirFS(dir), ".")
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to list directory")
+	}
+
+	if len(moduleManifests(b.discovery.manifestPattern(), files)) == 0 {
+		return nil, nil
+	}
+
+	root := b.rootContaining(dir)
+	if root == "" || (b.CwdIsModule && dir != root) {
+		return nil, nil
+	}
+
+	// the subdirectories of a module are never searched for other modules.
+	for i, mod := range b.Modules {
+		if i != existing && strings.HasPrefix(dir, mod.Fullpath+string(filepath.Separator)) {
+			return nil, nil
+		}
+	}
+
+	finder, err := newModuleFinder(os.DirFS(root), root, b.discovery)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to newModuleFinder")
+	}
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Rel path")
+	}
+
+	rel = filepath.ToSlash(rel)
+	if rel != "." && !finder.searches(rel) {
+		return nil, nil
+	}
+
+	mod, err := finder.getModuleFromFiles(rel, files)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to getModuleFromFiles")
+	}
+
+	return mod, nil
+}
+
+// rootContaining returns the root of the context (one of its Roots, or its working directory) that is or
+// contains dir, or an empty string if there is none.
+func (b *Context) rootContaining(dir string) string {
+	roots := b.Roots
+	if len(roots) == 0 {
+		roots = []string{b.Cwd}
+	}
+
+	for _, root := range roots {
+		if root != "" && (dir == root || strings.HasPrefix(dir, root+string(filepath.Separator))) {
+			return root
+		}
+	}
+
+	return ""
+}
+
+// moduleIndexContaining returns the index of the module whose directory (or source directory) contains path,
+// preferring the most deeply nested one, or -1 if there is none.
+func (b *Context) moduleIndexContaining(path string) int {
+	found, foundLen := -1, 0
+
+	for i, mod := range b.Modules {
+		for _, dir := range []string{mod.Fullpath, mod.SourceDir} {
+			if dir == "" || len(dir) <= foundLen {
+				continue
+			}
+
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				found, foundLen = i, len(dir)
+			}
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,148 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suborbital/subo/subo/util"
+)
+
+func TestContext_Refresh(t *testing.T) {
+	root := t.TempDir()
+	authDir := writeModuleDir(t, root, "auth", "name: auth\nlang: rust\n")
+
+	ctx, err := ForDirectory(root)
+	require.NoError(t, err)
+	require.Len(t, ctx.Modules, 1)
+
+	tests := []struct {
+		name       string
+		setup      func() string
+		wantName   string
+		wantChange ModuleChange
+		wantMods   []string
+	}{
+		{
+			name: "source file",
+			setup: func() string {
+				return filepath.Join(authDir, "src", "lib.rs")
+			},
+			wantName:   "auth",
+			wantChange: ModuleSourceChanged,
+			wantMods:   []string{"auth"},
+		},
+		{
+			name: "unrelated file",
+			setup: func() string {
+				return filepath.Join(root, "README.md")
+			},
+			wantChange: ModuleUnchanged,
+			wantMods:   []string{"auth"},
+		},
+		{
+			name: "manifest changed",
+			setup: func() string {
+				path := filepath.Join(authDir, ".module.yaml")
+				require.NoError(t, ioutil.WriteFile(path, []byte("name: auth\nlang: tinygo\n"), util.PermFile))
+
+				return path
+			},
+			wantName:   "auth",
+			wantChange: ModuleManifestChanged,
+			wantMods:   []string{"auth"},
+		},
+		{
+			name: "manifest added",
+			setup: func() string {
+				return filepath.Join(writeModuleDir(t, root, "greet", "name: greet\nlang: swift\n"), ".module.yaml")
+			},
+			wantName:   "greet",
+			wantChange: ModuleAdded,
+			wantMods:   []string{"auth", "greet"},
+		},
+		{
+			name: "module directory removed",
+			setup: func() string {
+				require.NoError(t, os.RemoveAll(authDir))
+
+				return authDir
+			},
+			wantName:   "auth",
+			wantChange: ModuleRemoved,
+			wantMods:   []string{"greet"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, change, err := ctx.Refresh(tt.setup())
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantChange, change)
+
+			mods := []string{}
+			for _, mod := range ctx.Modules {
+				mods = append(mods, mod.Name)
+			}
+
+			assert.Equal(t, tt.wantMods, mods)
+
+			if change == ModuleManifestChanged {
+				assert.Equal(t, "tinygo", ctx.Modules[0].Module.Lang, "the updated manifest should have been re-read")
+			}
+		})
+	}
+}
+
+func TestContext_Refresh_DiscoveryOptions(t *testing.T) {
+	root := t.TempDir()
+	writeModuleDir(t, root, filepath.Join("payments", "charge"), "name: charge\nlang: rust\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".suboignore"), []byte("scratch\n"), util.PermFile))
+
+	ctx, err := ForDirectoryWithOptions(root, Options{Langs: []string{"rust"}, NamespaceFromParentDir: true})
+	require.NoError(t, err)
+	require.Len(t, ctx.Modules, 1)
+
+	refresh := func(dir string) (string, ModuleChange, error) {
+		return ctx.Refresh(filepath.Join(dir, ".module.yaml"))
+	}
+
+	name, change, err := refresh(writeModuleDir(t, root, filepath.Join("payments", "refund"), "name: refund\nlang: rust\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "refund", name)
+	assert.Equal(t, ModuleAdded, change)
+	assert.Equal(t, "payments", ctx.Modules[1].Module.Namespace, "the namespace is derived from the parent directory")
+
+	_, change, err = refresh(writeModuleDir(t, root, "scratch", "name: scratch\nlang: rust\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ModuleUnchanged, change, "an ignored directory is not added")
+
+	_, change, err = refresh(writeModuleDir(t, root, "web", "name: web\nlang: swift\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ModuleUnchanged, change, "a language that was not requested is not added")
+
+	_, change, err = refresh(writeModuleDir(t, root, filepath.Join("payments", "charge", "nested"), "name: nested\nlang: rust\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ModuleUnchanged, change, "a module within another module is not added")
+
+	_, _, err = refresh(writeModuleDir(t, root, filepath.Join("payments", "charge2"), "name: charge\nlang: rust\n"))
+	assert.ErrorContains(t, err, "same name")
+
+	name, change, err = refresh(writeModuleDir(t, root, filepath.Join("payments", "refund"), "name: refund\nlang: swift\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "refund", name)
+	assert.Equal(t, ModuleRemoved, change, "a module whose language is no longer requested is removed")
+
+	mods := []string{}
+	for _, mod := range ctx.Modules {
+		mods = append(mods, mod.Name)
+	}
+
+	assert.Equal(t, []string{"charge"}, mods)
+}
@@ -1,26 +1,40 @@
 package project
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/subo/subo/util"
+	"github.com/suborbital/systemspec/capabilities"
 	"github.com/suborbital/systemspec/fqmn"
 	"github.com/suborbital/systemspec/tenant"
+	"github.com/suborbital/systemspec/tenant/executable"
 )
 
+// DefaultTenantConfigFilename is the name of the tenant config file in a project directory.
+const DefaultTenantConfigFilename = "tenant.json"
+
 // WriteTenantConfig writes a tenant config to disk.
 func WriteTenantConfig(cwd string, cfg *tenant.Config) error {
-	filePath := filepath.Join(cwd, "tenant.json")
+	return WriteTenantConfigFile(filepath.Join(cwd, DefaultTenantConfigFilename), cfg)
+}
 
+// WriteTenantConfigFile writes a tenant config to disk at filePath.
+func WriteTenantConfigFile(filePath string, cfg *tenant.Config) error {
 	configBytes, err := cfg.Marshal()
 	if err != nil {
 		return errors.Wrap(err, "failed to Marshal")
@@ -33,21 +47,217 @@ func WriteTenantConfig(cwd string, cfg *tenant.Config) error {
 	return nil
 }
 
-// readTenantConfig finds a tenant.json from disk but does not validate it.
-func readTenantConfig(cwd string) (*tenant.Config, error) {
-	filePath := filepath.Join(cwd, "tenant.json")
+// TenantConfigTransform modifies a tenant config before it is bundled, such as to apply environment-specific overrides.
+type TenantConfigTransform func(*tenant.Config) error
+
+// AddTenantConfigTransform registers a transform to be run by ApplyTenantConfigTransforms.
+// Transforms run in the order they were added.
+func (b *Context) AddTenantConfigTransform(transform TenantConfigTransform) {
+	b.tenantConfigTransforms = append(b.tenantConfigTransforms, transform)
+}
+
+// ApplyTenantConfigTransforms runs each registered transform against the context's tenant config,
+// stopping at the first one that returns an error.
+func (b *Context) ApplyTenantConfigTransforms() error {
+	if b.TenantConfig == nil {
+		return nil
+	}
+
+	return b.applyTenantConfigTransforms(b.TenantConfig)
+}
+
+// TransformedTenantConfig returns a copy of the context's tenant config with each registered transform applied,
+// leaving the context's own config (the one written back to tenant.json) as it is, so that transforms such as
+// environment-specific overrides only reach the bundle. It returns nil if the context has no tenant config.
+func (b *Context) TransformedTenantConfig() (*tenant.Config, error) {
+	if b.TenantConfig == nil {
+		return nil, nil
+	}
+
+	cfg := copyTenantConfig(b.TenantConfig)
+
+	if err := b.applyTenantConfigTransforms(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (b *Context) applyTenantConfigTransforms(cfg *tenant.Config) error {
+	for i, transform := range b.tenantConfigTransforms {
+		if err := transform(cfg); err != nil {
+			return errors.Wrapf(err, "tenant config transform %d failed", i)
+		}
+	}
+
+	return nil
+}
+
+// copyTenantConfig returns a deep copy of cfg that shares no pointers, slices or maps with it (see deepCopy).
+func copyTenantConfig(cfg *tenant.Config) *tenant.Config {
+	return deepCopy(reflect.ValueOf(cfg)).Interface().(*tenant.Config)
+}
+
+// deepCopy returns a copy of v that shares no pointers, slices or maps with it. Fields that aren't part of a tenant
+// config's JSON (unexported or tagged json:"-", such as a capability's logger) are shared rather than copied.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.New(v.Elem().Type())
+		c.Elem().Set(deepCopy(v.Elem()))
+
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.IsExported() && field.Tag.Get("json") != "-" {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+
+		return c
+	}
+
+	return v
+}
+
+// tenantConfigCacheEntry is a tenant config that parsed successfully and its contents, along with its file info.
+type tenantConfigCacheEntry struct {
+	modTime time.Time
+	size    int64
+	config  *tenant.Config
+	raw     []byte
+}
+
+// tenantConfigCache holds parsed tenant configs keyed by file path, so that repeated calls to ForDirectory
+// (such as from a file watcher) don't re-read or re-parse an unchanged file. Each caller gets a copy of the
+// cached config that shares nothing with any other.
+var tenantConfigCache = struct {
+	sync.Mutex
+	entries map[string]tenantConfigCacheEntry
+}{
+	entries: map[string]tenantConfigCacheEntry{},
+}
+
+// ClearTenantConfigCache discards all cached tenant configs, forcing them to be re-read from disk.
+func ClearTenantConfigCache() {
+	tenantConfigCache.Lock()
+	defer tenantConfigCache.Unlock()
+
+	tenantConfigCache.entries = map[string]tenantConfigCacheEntry{}
+}
+
+// GenerateTenantConfig synthesizes a minimal tenant config for the context's modules, giving each module
+// a workflow of the same name that calls it. It is intended as a starting point for projects without a tenant.json.
+func (b *Context) GenerateTenantConfig(identifier string, version int64) *tenant.Config {
+	defaultCaps := capabilities.DefaultCapabilityConfig()
+
+	cfg := &tenant.Config{
+		Identifier:    identifier,
+		SpecVersion:   1,
+		TenantVersion: version,
+		DefaultNamespace: tenant.NamespaceConfig{
+			Name:         fqmn.NamespaceDefault,
+			Capabilities: &defaultCaps,
+		},
+		Namespaces: []tenant.NamespaceConfig{},
+	}
+
+	namespaceIndex := map[string]int{}
+
+	for _, mod := range b.Modules {
+		wf := tenant.Workflow{
+			Name: mod.Name,
+			Steps: []executable.Executable{
+				{
+					ExecutableMod: executable.ExecutableMod{
+						FQMN: fmt.Sprintf("/name/%s/%s", mod.Module.Namespace, mod.Name),
+					},
+				},
+			},
+		}
+
+		if mod.Module.Namespace == fqmn.NamespaceDefault {
+			cfg.DefaultNamespace.Workflows = append(cfg.DefaultNamespace.Workflows, wf)
+			continue
+		}
+
+		i, exists := namespaceIndex[mod.Module.Namespace]
+		if !exists {
+			cfg.Namespaces = append(cfg.Namespaces, tenant.NamespaceConfig{Name: mod.Module.Namespace})
+			i = len(cfg.Namespaces) - 1
+			namespaceIndex[mod.Module.Namespace] = i
+		}
 
-	tenantBytes, err := ioutil.ReadFile(filePath)
+		cfg.Namespaces[i].Workflows = append(cfg.Namespaces[i].Workflows, wf)
+	}
+
+	return cfg
+}
+
+// readTenantConfig reads the tenant config at filePath from disk but does not validate it, returning it
+// along with the file's contents. The config is cached until the file's modification time or size changes.
+func readTenantConfig(filePath string) (*tenant.Config, []byte, error) {
+	info, err := os.Stat(filePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to ReadFile for Directive")
+		return nil, nil, errors.Wrap(err, "failed to Stat for Directive")
 	}
 
-	t := &tenant.Config{}
-	if err := t.Unmarshal(tenantBytes); err != nil {
-		return nil, errors.Wrap(err, "failed to Unmarshal Directive")
+	tenantConfigCache.Lock()
+	defer tenantConfigCache.Unlock()
+
+	entry, ok := tenantConfigCache.entries[filePath]
+	if !ok || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		tenantBytes, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to ReadFile for Directive")
+		}
+
+		t := &tenant.Config{}
+		if err := t.Unmarshal(tenantBytes); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to Unmarshal Directive")
+		}
+
+		entry = tenantConfigCacheEntry{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			config:  t,
+			raw:     tenantBytes,
+		}
+
+		tenantConfigCache.entries[filePath] = entry
 	}
 
-	return t, nil
+	// callers modify the returned config and bytes, so each gets its own copy of the cached ones.
+	return copyTenantConfig(entry.config), append([]byte{}, entry.raw...), nil
 }
 
 // readQueriesFile finds a queries.yaml from disk.
@@ -191,14 +401,8 @@ func getWorkflowFQMNList(cfg *tenant.Config) []string {
 	}
 
 	for _, h := range workflows {
-		for _, step := range h.Steps {
-			if step.IsFn() {
-				modMap[step.ExecutableMod.FQMN] = true
-			} else if step.IsGroup() {
-				for _, mod := range step.Group {
-					modMap[mod.FQMN] = true
-				}
-			}
+		for _, modFQMN := range workflowFQMNs(h) {
+			modMap[modFQMN] = true
 		}
 	}
 
@@ -210,6 +414,308 @@ func getWorkflowFQMNList(cfg *tenant.Config) []string {
 	return mods
 }
 
+// workflowFQMNs returns the FQMNs of every module called by a workflow's steps, in step order.
+func workflowFQMNs(wf tenant.Workflow) []string {
+	mods := []string{}
+
+	for _, step := range wf.Steps {
+		if step.IsFn() {
+			mods = append(mods, step.ExecutableMod.FQMN)
+		} else if step.IsGroup() {
+			for _, mod := range step.Group {
+				mods = append(mods, mod.FQMN)
+			}
+		}
+	}
+
+	return mods
+}
+
+// ValidateWorkflowModules checks that every module referenced by the tenant config's workflows
+// has a module directory in the context, returning one error for each that is missing.
+func (b *Context) ValidateWorkflowModules() []error {
+	if b.TenantConfig == nil {
+		return nil
+	}
+
+	errs := []error{}
+
+	namespaces := append([]tenant.NamespaceConfig{b.TenantConfig.DefaultNamespace}, b.TenantConfig.Namespaces...)
+
+	for _, ns := range namespaces {
+		for _, wf := range ns.Workflows {
+			for _, modFQMN := range workflowFQMNs(wf) {
+				FQMN, err := fqmn.Parse(modFQMN)
+				if err != nil {
+					errs = append(errs, errors.Wrapf(err, "workflow %s: failed to parse FQMN %s", wf.Name, modFQMN))
+					continue
+				}
+
+				if !b.ModuleExists(FQMN.Name) {
+					errs = append(errs, fmt.Errorf("workflow %s references module %s, but no module was found at %s", wf.Name, FQMN.Name, filepath.Join(b.Cwd, FQMN.Name)))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// ValidateHandlerChains checks the steps of each of the tenant config's workflows, returning one error for each
+// step that references a module with no module directory in the context, and for each step whose 'with' refers
+// to its own result. It also reports workflows whose triggers form a cycle, where a workflow's sinkTopic
+// (eventually) triggers that same workflow again. Each error names the workflow and the position of the step.
+func (b *Context) ValidateHandlerChains() []error {
+	if b.TenantConfig == nil {
+		return nil
+	}
+
+	errs := []error{}
+
+	namespaces := append([]tenant.NamespaceConfig{b.TenantConfig.DefaultNamespace}, b.TenantConfig.Namespaces...)
+
+	for _, ns := range namespaces {
+		for _, wf := range ns.Workflows {
+			for i, step := range wf.Steps {
+				mods := []executable.ExecutableMod{}
+				if step.IsFn() {
+					mods = append(mods, step.ExecutableMod)
+				} else if step.IsGroup() {
+					mods = append(mods, step.Group...)
+				}
+
+				for _, mod := range mods {
+					errs = append(errs, b.validateHandlerStep(wf.Name, i, mod)...)
+				}
+			}
+		}
+
+		errs = append(errs, workflowTriggerCycles(ns.Workflows)...)
+	}
+
+	return errs
+}
+
+// validateHandlerStep checks a single module call at position index of the named workflow.
+func (b *Context) validateHandlerStep(workflow string, index int, mod executable.ExecutableMod) []error {
+	FQMN, err := fqmn.Parse(mod.FQMN)
+	if err != nil {
+		return []error{errors.Wrapf(err, "workflow %s step %d: failed to parse FQMN %s", workflow, index, mod.FQMN)}
+	}
+
+	errs := []error{}
+
+	if !b.ModuleExists(FQMN.Name) {
+		errs = append(errs, fmt.Errorf("workflow %s step %d: references module %s, which does not exist", workflow, index, FQMN.Name))
+	}
+
+	result := FQMN.Name
+	if mod.As != "" {
+		result = mod.As
+	}
+
+	for _, key := range mod.With {
+		if key == result || key == mod.FQMN {
+			errs = append(errs, fmt.Errorf("workflow %s step %d: module %s references its own result %s", workflow, index, FQMN.Name, key))
+		}
+	}
+
+	return errs
+}
+
+// workflowTriggerCycles returns an error for each cycle of workflows that trigger each other,
+// where a workflow whose trigger has a sinkTopic triggers every workflow with that topic.
+func workflowTriggerCycles(workflows []tenant.Workflow) []error {
+	byTopic := map[string][]string{}
+	for _, wf := range workflows {
+		for _, trigger := range wf.Triggers {
+			if trigger.Topic != "" {
+				byTopic[trigger.Topic] = append(byTopic[trigger.Topic], wf.Name)
+			}
+		}
+	}
+
+	next := map[string][]string{}
+	for _, wf := range workflows {
+		for _, trigger := range wf.Triggers {
+			if trigger.SinkTopic != "" {
+				next[wf.Name] = append(next[wf.Name], byTopic[trigger.SinkTopic]...)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := map[string]int{}
+	errs := []error{}
+
+	var visit func(name string, chain []string)
+	visit = func(name string, chain []string) {
+		state[name] = visiting
+		chain = append(chain, name)
+
+		for _, n := range next[name] {
+			switch state[n] {
+			case visiting:
+				start := 0
+				for i, c := range chain {
+					if c == n {
+						start = i
+						break
+					}
+				}
+
+				cycle := append(append([]string{}, chain[start:]...), n)
+				errs = append(errs, fmt.Errorf("workflow %s: triggers form a cycle: %s", n, strings.Join(cycle, " -> ")))
+			case unvisited:
+				visit(n, chain)
+			}
+		}
+
+		state[name] = done
+	}
+
+	for _, wf := range workflows {
+		if state[wf.Name] == unvisited {
+			visit(wf.Name, nil)
+		}
+	}
+
+	return errs
+}
+
+// ValidateTenantConfigSchema checks the tenant config file at TenantConfigPath against the structure of
+// tenant.Config, catching mistakes that are otherwise silently ignored when it is loaded: unknown or misspelled
+// keys, and values of the wrong type. Misspelled top-level keys are reported along with the key that was
+// most likely intended. A missing tenant config is not an error.
+func (b *Context) ValidateTenantConfigSchema() error {
+	if b.TenantConfigPath == "" {
+		return nil
+	}
+
+	configBytes, err := ioutil.ReadFile(b.TenantConfigPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to ReadFile")
+	}
+
+	topLevel := map[string]json.RawMessage{}
+	if err := json.Unmarshal(configBytes, &topLevel); err != nil {
+		return errors.Wrapf(err, "%s is not valid JSON", b.TenantConfigPath)
+	}
+
+	known := jsonFieldNames(reflect.TypeOf(tenant.Config{}))
+
+	problems := []string{}
+	for key := range topLevel {
+		if known[key] {
+			continue
+		}
+
+		if suggestion := closestKey(key, known); suggestion != "" {
+			problems = append(problems, fmt.Sprintf("unknown key %q, did you mean %q?", key, suggestion))
+		} else {
+			problems = append(problems, fmt.Sprintf("unknown key %q", key))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s: %s", b.TenantConfigPath, strings.Join(problems, "; "))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(configBytes))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&tenant.Config{}); err != nil {
+		return errors.Wrapf(err, "%s does not match the tenant config schema", b.TenantConfigPath)
+	}
+
+	return nil
+}
+
+// jsonFieldNames returns the JSON keys of the fields of the struct type t.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = t.Field(i).Name
+		}
+
+		names[name] = true
+	}
+
+	return names
+}
+
+// closestKey returns the key in known that is most similar to key, if any is close enough to be a likely typo.
+func closestKey(key string, known map[string]bool) string {
+	best := ""
+	bestDistance := 3
+
+	for k := range known {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(k)); d < bestDistance || (d == bestDistance && k < best) {
+			best, bestDistance = k, d
+		}
+	}
+
+	if bestDistance > 2 {
+		return ""
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev = curr
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	m := a
+	if b < m {
+		m = b
+	}
+
+	if c < m {
+		m = c
+	}
+
+	return m
+}
+
 func DockerNameFromConfig(cfg *tenant.Config) (string, error) {
 	identParts := strings.Split(cfg.Identifier, ".")
 	if len(identParts) != 3 {
@@ -2,12 +2,15 @@ package command
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/suborbital/subo/builder"
 	"github.com/suborbital/subo/packager"
+	"github.com/suborbital/subo/project"
 	"github.com/suborbital/subo/subo/util"
 )
 
@@ -23,30 +26,72 @@ func BuildCmd() *cobra.Command {
 				dir = args[0]
 			}
 
-			bdr, err := builder.ForDirectory(&util.PrintLogger{}, &builder.DefaultBuildConfig, dir)
+			namespaceFromDir, _ := cmd.Flags().GetBool("namespace-from-dir")
+
+			config := builder.DefaultBuildConfig
+			config.ContinueOnFailure, _ = cmd.Flags().GetBool("continue-on-failure")
+
+			bdr, err := builder.ForDirectoryWithOptions(&util.PrintLogger{}, &config, dir, project.Options{NamespaceFromParentDir: namespaceFromDir})
 			if err != nil {
-				return errors.Wrap(err, "failed to builder.ForDirectory")
+				return errors.Wrap(err, "failed to builder.ForDirectoryWithOptions")
 			}
 
 			if len(bdr.Context.Modules) == 0 {
 				return errors.New("🚫 no modules found in current directory (no .module.yaml files found)")
 			}
 
+			if dupes := bdr.Context.DuplicateModuleNames(); len(dupes) > 0 {
+				return fmt.Errorf("🚫 multiple modules share the same name: %s", strings.Join(dupes, ", "))
+			}
+
+			util.LogInfo(bdr.Context.Summary())
+
 			if bdr.Context.CwdIsModule {
 				util.LogInfo("building single module (run from project root to create bundle)")
 			}
 
+			if orphans, err := bdr.Context.OrphanedModules(); err == nil && len(orphans) > 0 {
+				util.LogWarn(fmt.Sprintf("found .wasm files that don't belong to any module, run `subo clean` to remove them: %s", strings.Join(orphans, ", ")))
+			}
+
 			langs, _ := cmd.Flags().GetStringSlice("langs")
-			bdr.Context.Langs = langs
+			bdr.Context.SetBuildLangs(langs)
+
+			excludeLangs, _ := cmd.Flags().GetStringSlice("exclude-langs")
+			bdr.Context.SetExcludeLangs(excludeLangs)
+
+			filter, _ := cmd.Flags().GetString("filter")
+			if err := bdr.Context.SetBuildFilter(filter); err != nil {
+				return errors.Wrap(err, "failed to SetBuildFilter")
+			}
+
+			bdr.Context.SkipPrereqs, _ = cmd.Flags().GetBool("no-prereqs")
 
 			noBundle, _ := cmd.Flags().GetBool("no-bundle")
-			shouldBundle := !noBundle && !bdr.Context.CwdIsModule && len(langs) == 0
+			shouldBundle := !noBundle && !bdr.Context.CwdIsModule && len(langs) == 0 && len(excludeLangs) == 0 && filter == ""
 			shouldDockerBuild, _ := cmd.Flags().GetBool("docker")
 
 			if bdr.Context.CwdIsModule && shouldDockerBuild {
 				return errors.New("🚫 cannot build Docker image for a single module (must be a project)")
 			}
 
+			// the manifest describes every module, which a build of only some of them can't do.
+			writeManifest, _ := cmd.Flags().GetBool("manifest")
+			if writeManifest && (len(langs) > 0 || len(excludeLangs) > 0 || filter != "") {
+				return errors.New("🚫 cannot write a build manifest when only some modules are built (--langs, --exclude-langs or --filter)")
+			}
+
+			bundleFormat, _ := cmd.Flags().GetString("bundle-format")
+			switch packager.BundleFormat(bundleFormat) {
+			case packager.BundleFormatZip:
+			case packager.BundleFormatOCI:
+				if shouldDockerBuild {
+					return errors.New("🚫 cannot build Docker image from an OCI bundle (use --bundle-format zip)")
+				}
+			default:
+				return fmt.Errorf("🚫 unknown bundle format %s, must be zip or oci", bundleFormat)
+			}
+
 			useNative, _ := cmd.Flags().GetBool("native")
 			makeTarget, _ := cmd.Flags().GetString("make")
 
@@ -64,16 +109,36 @@ func BuildCmd() *cobra.Command {
 				bdr.Context.RelDockerPath = relPath
 			}
 
+			if bundleDir, _ := cmd.Flags().GetString("bundle-dir"); bundleDir != "" {
+				if err := bdr.Context.SetBundleDir(bundleDir); err != nil {
+					return errors.Wrap(err, "failed to SetBundleDir")
+				}
+			}
+
 			builderTag, _ := cmd.Flags().GetString("builder-tag")
 			if builderTag != "" {
 				bdr.Context.BuilderTag = builderTag
 			}
 
-			if makeTarget != "" {
-				util.LogStart(fmt.Sprintf("make %s", makeTarget))
-				_, err = util.Command.Run(fmt.Sprintf("make %s", makeTarget))
-				if err != nil {
-					return errors.Wrapf(err, "🚫 failed to make %s", makeTarget)
+			if err := bdr.Context.CheckRuntimeVersion(); err != nil {
+				return errors.Wrap(err, "🚫 failed to CheckRuntimeVersion")
+			}
+
+			if err := bdr.Context.CheckBuilderCompatibility(); err != nil {
+				return errors.Wrap(err, "🚫 failed to CheckBuilderCompatibility")
+			}
+
+			if shouldBundle {
+				if err := bdr.Context.ValidateTenantConfigSchema(); err != nil {
+					return errors.Wrap(err, "🚫 failed to ValidateTenantConfigSchema")
+				}
+
+				if errs := bdr.Context.ValidateHandlerChains(); len(errs) > 0 {
+					for _, e := range errs {
+						util.LogFail(e.Error())
+					}
+
+					return errors.New("🚫 workflows reference modules that do not exist or form a cycle")
 				}
 			}
 
@@ -85,16 +150,79 @@ func BuildCmd() *cobra.Command {
 				toolchain = builder.ToolchainDocker
 			}
 
+			if dryRun, _ := cmd.Flags().GetBool(dryRunFlag); dryRun {
+				steps, err := bdr.Plan(toolchain)
+				if err != nil {
+					return errors.Wrap(err, "failed to Plan")
+				}
+
+				for _, step := range steps {
+					fmt.Println(step.String())
+				}
+
+				return nil
+			}
+
+			if makeTarget != "" {
+				util.LogStart(fmt.Sprintf("make %s", makeTarget))
+				_, err = util.Command.Run(fmt.Sprintf("make %s", makeTarget))
+				if err != nil {
+					return errors.Wrapf(err, "🚫 failed to make %s", makeTarget)
+				}
+			}
+
+			if errs := bdr.Context.PreflightSources(); len(errs) > 0 {
+				for _, e := range errs {
+					util.LogFail(e.Error())
+				}
+
+				return errors.New("🚫 modules are missing source files")
+			}
+
 			// The builder does the majority of the work.
 			if err := bdr.BuildWithToolchain(toolchain); err != nil {
+				var failedErr *builder.BuildFailedError
+				if errors.As(err, &failedErr) {
+					if len(failedErr.Built) > 0 {
+						util.LogInfo(fmt.Sprintf("built %s", strings.Join(failedErr.Built, ", ")))
+					}
+
+					for _, e := range failedErr.Failures {
+						util.LogFail(e.Error())
+					}
+
+					return fmt.Errorf("🚫 %d build(s) failed", len(failedErr.Failures))
+				}
+
 				return errors.Wrap(err, "failed to BuildWithToolchain")
 			}
 
+			// Only report sizes when every module was built, otherwise some are expected to be missing.
+			if len(langs) == 0 && len(excludeLangs) == 0 && filter == "" {
+				sizes, total, err := bdr.Context.ModuleSizes()
+				if err != nil {
+					return errors.Wrap(err, "failed to ModuleSizes")
+				}
+
+				for _, mod := range bdr.Context.Modules {
+					util.LogInfo(fmt.Sprintf("%s: %d bytes", mod.NamespacedName(), sizes[mod.NamespacedName()]))
+				}
+
+				util.LogInfo(fmt.Sprintf("total: %d bytes", total))
+
+				if writeManifest {
+					manifestPath := filepath.Join(filepath.Dir(bdr.Context.Bundle.Fullpath), project.DefaultBuildManifestFilename)
+					if err := bdr.Context.WriteManifest(manifestPath); err != nil {
+						return errors.Wrap(err, "failed to WriteManifest")
+					}
+				}
+			}
+
 			pkgr := packager.New(&util.PrintLogger{})
 			pkgJobs := []packager.PackageJob{}
 
 			if shouldBundle {
-				pkgJobs = append(pkgJobs, packager.NewBundlePackageJob())
+				pkgJobs = append(pkgJobs, packager.NewBundlePackageJobWithFormat(packager.BundleFormat(bundleFormat)))
 			}
 
 			if shouldDockerBuild && !bdr.Context.CwdIsModule {
@@ -110,13 +238,22 @@ func BuildCmd() *cobra.Command {
 	}
 
 	cmd.Flags().Bool("no-bundle", false, "if passed, a .wasm.zip bundle will not be generated")
+	cmd.Flags().Bool("continue-on-failure", false, "build every module even if some fail, then report all of the failures")
+	cmd.Flags().Bool("no-prereqs", false, "do not install missing prerequisites (such as node_modules) before building")
 	cmd.Flags().Bool("native", false, "use native (locally installed) toolchain rather than Docker")
 	cmd.Flags().String("make", "", "execute the provided Make target before building the project bundle")
 	cmd.Flags().Bool("docker", false, "build your project's Dockerfile. It will be tagged {identifier}:{appVersion}")
 	cmd.Flags().StringSlice("langs", []string{}, "build only modules for the listed languages (comma-seperated)")
+	cmd.Flags().StringSlice("exclude-langs", []string{}, "do not build modules for the listed languages, even if passed to '--langs' (comma-seperated)")
+	cmd.Flags().String("filter", "", "build only modules whose name matches the provided glob pattern, such as 'auth-*'")
 	cmd.Flags().String("mountpath", "", "if passed, the Docker builders will mount their volumes at the provided path")
 	cmd.Flags().String("relpath", "", "if passed, the Docker builders will run `subo build` using the provided path, relative to '--mountpath'")
-	cmd.Flags().String("builder-tag", "", "use the provided tag for builder images")
+	cmd.Flags().Bool("manifest", false, "write a build-manifest.json describing each built module alongside the bundle")
+	cmd.Flags().String("bundle-format", string(packager.BundleFormatZip), "write the bundle as a .wasm.zip file (zip) or as an OCI artifact in an OCI image layout directory (oci)")
+	cmd.Flags().String("bundle-dir", "", "write the bundle to the provided directory rather than the project directory")
+	cmd.Flags().Bool(dryRunFlag, false, "print the commands that would be run to build the project, without running them")
+	cmd.Flags().Bool("namespace-from-dir", false, "default the namespace of modules that don't set one to the name of their parent directory, rather than 'default'")
+	cmd.Flags().String("builder-tag", "", "use the provided tag for builder images (overrides $SUBO_BUILDER_TAG)")
 
 	return cmd
 }
@@ -63,6 +63,20 @@ func CleanCmd() *cobra.Command {
 						}
 					}
 				}
+
+				// Delete any other artifacts the module's build declares.
+				for _, artifact := range r.Artifacts {
+					matches, _ := filepath.Glob(filepath.Join(r.Fullpath, artifact))
+
+					for _, match := range matches {
+						if err := os.RemoveAll(match); err != nil {
+							util.LogInfo(errors.Wrap(err, "🚫 failed to Remove").Error())
+							continue
+						}
+
+						util.LogDone(fmt.Sprintf("removed %s", strings.TrimPrefix(match, r.Fullpath+string(filepath.Separator))))
+					}
+				}
 			}
 
 			util.LogDone("cleaned")
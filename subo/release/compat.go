@@ -0,0 +1,44 @@
+package release
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+)
+
+// RuntimeCompatibility pairs a range of E2Core versions with the range of subo versions whose
+// builder images produce modules that those versions of E2Core can run.
+type RuntimeCompatibility struct {
+	RuntimeVersions string
+	SuboVersions    string
+}
+
+// RuntimeCompatibilityTable lists which builder images can be used for each version of E2Core. Builder images
+// are tagged with the version of subo that published them, such as v0.6.0. Add a row whenever a release of
+// subo or E2Core changes which modules can be run, with the newest versions last.
+var RuntimeCompatibilityTable = []RuntimeCompatibility{
+	{RuntimeVersions: SupportedRuntimeVersions, SuboVersions: ">= 0.6.0, <= " + SuboVersion},
+}
+
+// CompatibleSuboVersions returns the range of subo versions whose builder images are compatible with
+// the given version of E2Core, according to RuntimeCompatibilityTable.
+func CompatibleSuboVersions(runtimeVersion string) (string, error) {
+	runtime, err := version.NewVersion(runtimeVersion)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse runtime version %s", runtimeVersion)
+	}
+
+	for _, row := range RuntimeCompatibilityTable {
+		constraint, err := version.NewConstraint(row.RuntimeVersions)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to NewConstraint %s", row.RuntimeVersions)
+		}
+
+		if constraint.Check(runtime) {
+			return row.SuboVersions, nil
+		}
+	}
+
+	return "", fmt.Errorf("runtime version %s has no known compatible builder images", runtimeVersion)
+}
@@ -11,3 +11,9 @@ const RuntimeVersion = "0.5.0"
 
 // SE2Version is the docker tag used for creating new SE2 deployments.
 const SE2Version = "0.4.2"
+
+// SupportedRuntimeVersions is the range of E2Core versions that modules built by this version of subo are compatible with.
+const SupportedRuntimeVersions = ">= 0.5.0, <= " + RuntimeVersion
+
+// ReactrVersion is the default version of the Reactr library downloaded by build prerequisites, such as the Grain API.
+const ReactrVersion = "0.15.1"
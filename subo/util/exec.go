@@ -2,9 +2,11 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -14,6 +16,35 @@ type CommandRunner interface {
 	RunInDir(cmd, dir string) (string, error)
 }
 
+// ContextCommandRunner is a CommandRunner that can also kill a command when a context is done.
+type ContextCommandRunner interface {
+	CommandRunner
+	RunInDirContext(ctx context.Context, cmd, dir string) (string, error)
+}
+
+// RunOptions are the extra settings that an OptionsCommandRunner can run a command with.
+type RunOptions struct {
+	// Env is added to the command's environment, formatted as KEY=value. Unlike the command line,
+	// it is never printed, so it can hold secrets.
+	Env []string
+	// Stdin is the command's standard input, if it is set.
+	Stdin io.Reader
+	// Silent keeps the command's output from being printed, it is still returned.
+	Silent bool
+	// Shell is the program and arguments that the command is passed to as its last argument, sh -c if it is empty.
+	Shell []string
+}
+
+// OptionsCommandRunner is a ContextCommandRunner that can also run a command with RunOptions.
+type OptionsCommandRunner interface {
+	ContextCommandRunner
+	RunInDirWithOptions(ctx context.Context, cmd, dir string, opts RunOptions) (string, error)
+}
+
+// commandWaitDelay is how long a killed command's output is waited on before it is abandoned, since a process
+// that the command started can keep its output open after the command itself has exited.
+const commandWaitDelay = 5 * time.Second
+
 type silentOutput bool
 
 const (
@@ -39,25 +70,42 @@ func NewCommandLineExecutor(silent silentOutput, writer io.Writer) *CommandLineE
 
 // Run runs a command, outputting to terminal and returning the full output and/or error.
 func (d *CommandLineExecutor) Run(cmd string) (string, error) {
-	return run(cmd, "", d.silent, d.writer)
+	return run(context.Background(), cmd, "", RunOptions{}, d.silent, d.writer)
 }
 
 // RunInDir runs a command in the specified directory and returns the full output or error.
 func (d *CommandLineExecutor) RunInDir(cmd, dir string) (string, error) {
-	return run(cmd, dir, d.silent, d.writer)
+	return run(context.Background(), cmd, dir, RunOptions{}, d.silent, d.writer)
+}
+
+// RunInDirContext runs a command in the specified directory and returns the full output or error.
+// The command is killed if ctx is done before it completes.
+func (d *CommandLineExecutor) RunInDirContext(ctx context.Context, cmd, dir string) (string, error) {
+	return run(ctx, cmd, dir, RunOptions{}, d.silent, d.writer)
 }
 
-func run(cmd, dir string, silent silentOutput, writer io.Writer) (string, error) {
+// RunInDirWithOptions runs a command in the specified directory with opts and returns the full output or error.
+// The command is killed if ctx is done before it completes.
+func (d *CommandLineExecutor) RunInDirWithOptions(ctx context.Context, cmd, dir string, opts RunOptions) (string, error) {
+	return run(ctx, cmd, dir, opts, d.silent, d.writer)
+}
+
+func run(ctx context.Context, cmd, dir string, opts RunOptions, silent silentOutput, writer io.Writer) (string, error) {
 	// you can uncomment this below if you want to see exactly the commands being run
 	// fmt.Println("▶️", cmd).
 
-	command := exec.Command("sh", "-c", cmd)
+	command := shellCommand(ctx, CommandArgs(opts.Shell, cmd))
 
 	command.Dir = dir
+	command.Stdin = opts.Stdin
+
+	if len(opts.Env) > 0 {
+		command.Env = append(os.Environ(), opts.Env...)
+	}
 
 	var outBuf bytes.Buffer
 
-	if silent {
+	if bool(silent) || opts.Silent {
 		command.Stdout = &outBuf
 		command.Stderr = &outBuf
 	} else if writer != nil {
@@ -78,3 +126,30 @@ func run(cmd, dir string, silent silentOutput, writer io.Writer) (string, error)
 
 	return outStr, nil
 }
+
+// shellCommand runs args, which run a command using a shell (see CommandArgs). When ctx is done, the command is
+// killed along with every process that it started (see killOnCancel).
+func shellCommand(ctx context.Context, args []string) *exec.Cmd {
+	command := exec.CommandContext(ctx, args[0], args[1:]...)
+	command.WaitDelay = commandWaitDelay
+
+	killOnCancel(command)
+
+	return command
+}
+
+// ShellArgs returns the arguments that run cmd using sh, which every command that subo runs is written for.
+func ShellArgs(cmd string) []string {
+	return []string{"sh", "-c", cmd}
+}
+
+// CommandArgs returns the arguments that run cmd using shell, or using sh if shell is empty (see ShellArgs).
+func CommandArgs(shell []string, cmd string) []string {
+	if len(shell) == 0 {
+		return ShellArgs(cmd)
+	}
+
+	args := make([]string, 0, len(shell)+1)
+
+	return append(append(args, shell...), cmd)
+}
@@ -2,7 +2,11 @@ package util
 
 import (
 	"bytes"
+	"context"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -71,3 +75,43 @@ func TestCommandRunner_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandLineExecutor_RunInDirWithOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	opts := RunOptions{Env: []string{"SUBO_TEST_SECRET=s3cret"}, Stdin: strings.NewReader("from stdin")}
+
+	got, err := NewCommandLineExecutor(SilentOutput, nil).RunInDirWithOptions(context.Background(), `echo "$SUBO_TEST_SECRET"; cat`, t.TempDir(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret\nfrom stdin", got)
+}
+
+func TestCommandLineExecutor_RunInDirContext_KillsChildren(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// sleep inherits the command's output, so it must be killed along with sh for the command to return.
+	start := time.Now()
+	_, err := NewCommandLineExecutor(NormalOutput, new(bytes.Buffer)).RunInDirContext(ctx, "sleep 30 && echo done", t.TempDir())
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), commandWaitDelay, "the command's children are killed with it")
+}
+
+func TestCommandLineExecutor_RunInDirWithOptions_Shell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	got, err := NewCommandLineExecutor(SilentOutput, nil).RunInDirWithOptions(context.Background(), "echo $0", t.TempDir(), RunOptions{Shell: []string{"sh", "-c"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "sh\n", got)
+
+	assert.Equal(t, []string{"sh", "-c", "echo hi"}, CommandArgs(nil, "echo hi"))
+	assert.Equal(t, []string{"bash", "-c", "echo hi"}, CommandArgs([]string{"bash", "-c"}, "echo hi"))
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killOnCancel starts command in its own process group and kills the whole group when its context is done,
+// so that the processes started by a shell command (such as `npm` in `cd x && npm install`) are killed with it.
+func killOnCancel(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	command.Cancel = func() error {
+		return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package util
+
+import "os/exec"
+
+// killOnCancel leaves command to be killed by its context, Windows has no process groups to kill. The processes
+// that it started are abandoned after commandWaitDelay.
+func killOnCancel(command *exec.Cmd) {}